@@ -1,16 +1,20 @@
 package api
 
 import (
+	"bufio"
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
-	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/shammishailaj/gronicle/pkg/scheduler"
 	"github.com/shammishailaj/gronicle/pkg/storage"
 )
 
@@ -18,7 +22,29 @@ import (
 type TaskRequest struct {
 	JobName         string `json:"job_name"`
 	Command         string `json:"command"`
-	IntervalSeconds int    `json:"interval_seconds"`
+	IntervalSeconds int    `json:"interval_seconds"` // Run again every N seconds; 0 runs the task once
+	Schedule        string `json:"schedule"`         // Cron expression or descriptor; takes precedence over interval_seconds
+	Timezone        string `json:"timezone"`         // IANA timezone the schedule is evaluated in; defaults to UTC
+	TimeoutSeconds  int    `json:"timeout_seconds"`  // Kill an attempt that runs longer than this; 0 means no timeout
+	// SampleIntervalSeconds is how often the task's processes are sampled while it runs; 0 uses metrics.sample_interval
+	SampleIntervalSeconds int `json:"sample_interval_seconds"`
+	// RedactPatterns are regular expressions masked in the task's output, on top of the global ones
+	RedactPatterns []string `json:"redact_patterns"`
+	// Env, WorkingDir, RunAsUser and RunAsGroup set up the environment every run executes in
+	Env        map[string]string `json:"env"`          // Environment variables set for every run; a run's own env takes precedence
+	SecretEnv  []string          `json:"secret_env"`   // Names of variables whose values are masked in the output, besides *_TOKEN, *_KEY, *_PASSWORD ones and the like
+	WorkingDir string            `json:"working_dir"`  // Absolute directory the command runs in
+	RunAsUser  string            `json:"run_as_user"`  // User name or UID to run as; requires gronicle to run as root
+	RunAsGroup string            `json:"run_as_group"` // Group name or GID to run as; defaults to the user's groups
+	// Limits are cgroup v2 resource limits applied to every attempt; omitted or zero fields are unlimited
+	Limits storage.ResourceLimits `json:"limits"`
+}
+
+// RunTaskRequest represents an on-demand run request. All fields are optional.
+type RunTaskRequest struct {
+	Args      []string          `json:"args"`       // Positional parameters available to the command as $1, $2, ...
+	Env       map[string]string `json:"env"`        // Environment variables set for this run only
+	SecretEnv []string          `json:"secret_env"` // Names of variables whose values are masked in this run's output, besides the task's
 }
 
 // AddTaskHandler handles POST requests to add a new task.
@@ -31,7 +57,71 @@ func AddTaskHandler(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		taskID, err := storage.InsertTask(db, taskReq.JobName, taskReq.Command, taskReq.IntervalSeconds)
+		if taskReq.IntervalSeconds < 0 {
+			http.Error(w, "interval_seconds must not be negative", http.StatusBadRequest)
+			return
+		}
+		if taskReq.TimeoutSeconds < 0 {
+			http.Error(w, "timeout_seconds must not be negative", http.StatusBadRequest)
+			return
+		}
+		if taskReq.SampleIntervalSeconds < 0 {
+			http.Error(w, "sample_interval_seconds must not be negative", http.StatusBadRequest)
+			return
+		}
+		if taskReq.Timezone == "" {
+			taskReq.Timezone = "UTC"
+		}
+		if _, err := scheduler.CompileRedactPatterns(taskReq.RedactPatterns); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := validateEnv(taskReq.Env); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := validateSecretEnv(taskReq.SecretEnv); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if taskReq.WorkingDir != "" && !filepath.IsAbs(taskReq.WorkingDir) {
+			http.Error(w, "working_dir must be an absolute path", http.StatusBadRequest)
+			return
+		}
+		if err := scheduler.ValidateRunAs(taskReq.RunAsUser, taskReq.RunAsGroup); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid run_as_user or run_as_group: %v", err), http.StatusBadRequest)
+			return
+		}
+		if err := validateLimits(taskReq.Limits); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		task := &storage.Task{
+			JobName:        taskReq.JobName,
+			Command:        taskReq.Command,
+			Interval:       time.Duration(taskReq.IntervalSeconds) * time.Second,
+			Schedule:       taskReq.Schedule,
+			Timezone:       taskReq.Timezone,
+			TimeoutSeconds: taskReq.TimeoutSeconds,
+			SampleInterval: taskReq.SampleIntervalSeconds,
+			RedactPatterns: taskReq.RedactPatterns,
+			Env:            taskReq.Env,
+			SecretEnv:      taskReq.SecretEnv,
+			WorkingDir:     taskReq.WorkingDir,
+			RunAsUser:      taskReq.RunAsUser,
+			RunAsGroup:     taskReq.RunAsGroup,
+			Limits:         taskReq.Limits,
+		}
+
+		nextRunAt, err := scheduler.FirstRunAt(task, time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		task.NextRunAt = nextRunAt
+
+		taskID, err := storage.InsertTask(db, task)
 		if err != nil {
 			http.Error(w, "Failed to add task", http.StatusInternalServerError)
 			return
@@ -45,7 +135,8 @@ func AddTaskHandler(db *sql.DB) http.HandlerFunc {
 	}
 }
 
-// GetTasksHandler handles GET requests to retrieve all tasks.
+// GetTasksHandler handles GET requests to retrieve all tasks, with the values of their secret
+// environment variables masked.
 func GetTasksHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		tasks, err := storage.FetchAllTasks(db)
@@ -53,13 +144,17 @@ func GetTasksHandler(db *sql.DB) http.HandlerFunc {
 			http.Error(w, "Failed to fetch tasks", http.StatusInternalServerError)
 			return
 		}
+		for i := range tasks {
+			maskTaskEnv(&tasks[i])
+		}
 
 		w.WriteHeader(http.StatusOK)
 		json.NewEncoder(w).Encode(tasks)
 	}
 }
 
-// GetTaskByIDHandler handles GET requests to fetch a specific task.
+// GetTaskByIDHandler handles GET requests to fetch a specific task, with the values of its secret
+// environment variables masked.
 func GetTaskByIDHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		taskID, taskIDErr := strconv.Atoi(mux.Vars(r)["id"])
@@ -73,12 +168,19 @@ func GetTaskByIDHandler(db *sql.DB) http.HandlerFunc {
 			http.Error(w, "Task not found", http.StatusNotFound)
 			return
 		}
+		maskTaskEnv(task)
 
 		w.WriteHeader(http.StatusOK)
 		json.NewEncoder(w).Encode(task)
 	}
 }
 
+// maskTaskEnv masks the values of a task's secret environment variables before it is returned, as
+// they are masked in its output. Secrets are only ever written through the API, never read back.
+func maskTaskEnv(task *storage.Task) {
+	task.Env = scheduler.MaskSecretEnv(task.Env, task.SecretEnv)
+}
+
 // DeleteTaskHandler handles DELETE requests to remove a task.
 func DeleteTaskHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -95,56 +197,686 @@ func DeleteTaskHandler(db *sql.DB) http.HandlerFunc {
 	}
 }
 
-// GetTaskLogsHandler handles GET requests to fetch logs for a specific task from S3.
-func GetTaskLogsHandler(s3Logger *storage.S3Logger) http.HandlerFunc {
+// GetTaskRunsHandler handles GET requests to list the run history of a task.
+func GetTaskRunsHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		taskID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid task ID", http.StatusBadRequest)
+			return
+		}
+
+		runs, err := storage.FetchTaskRuns(db, taskID)
+		if err != nil {
+			http.Error(w, "Failed to fetch task runs", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(runs)
+	}
+}
+
+// GetRunByIDHandler handles GET requests to fetch a run and its attempts.
+func GetRunByIDHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		runID, err := strconv.ParseInt(mux.Vars(r)["run_id"], 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid run ID", http.StatusBadRequest)
+			return
+		}
+
+		run, err := storage.FetchTaskRunByID(db, runID)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Run not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to fetch run", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(run)
+	}
+}
+
+// RunTaskNowHandler handles POST requests to run a task immediately, outside of its schedule.
+func RunTaskNowHandler(db *sql.DB, pool *scheduler.WorkerPool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		taskID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid task ID", http.StatusBadRequest)
+			return
+		}
+
+		var runReq RunTaskRequest
+		if err := json.NewDecoder(r.Body).Decode(&runReq); err != nil && err != io.EOF {
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+		if err := validateEnv(runReq.Env); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := validateSecretEnv(runReq.SecretEnv); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		task, err := storage.FetchTaskByID(db, taskID)
+		if err != nil {
+			http.Error(w, "Task not found", http.StatusNotFound)
+			return
+		}
+
+		runID, err := pool.RunNow(db, task, runReq.Args, runReq.Env, runReq.SecretEnv)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to queue run: %v", err), http.StatusServiceUnavailable)
+			return
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"run_id":  runID,
+			"message": "Run queued successfully",
+		})
+	}
+}
+
+// validateEnv rejects environment variable names that cannot be passed to a command.
+func validateEnv(env map[string]string) error {
+	for name, value := range env {
+		if name == "" || strings.ContainsAny(name, "=\x00") {
+			return fmt.Errorf("Invalid environment variable name %q", name)
+		}
+		if strings.ContainsRune(value, 0) {
+			return fmt.Errorf("Invalid value for environment variable %q", name)
+		}
+	}
+	return nil
+}
+
+// validateSecretEnv rejects secret_env entries that cannot name an environment variable.
+func validateSecretEnv(names []string) error {
+	for _, name := range names {
+		if name == "" || strings.ContainsAny(name, "=\x00") {
+			return fmt.Errorf("Invalid environment variable name %q in secret_env", name)
+		}
+	}
+	return nil
+}
+
+// validateLimits rejects resource limits cgroup v2 cannot apply.
+func validateLimits(limits storage.ResourceLimits) error {
+	if limits.CPU < 0 || (limits.CPU > 0 && limits.CPU < 0.01) {
+		// The kernel's smallest CPU quota is 1ms per 100ms period
+		return fmt.Errorf("limits.cpu must be 0 or at least 0.01, got %g", limits.CPU)
+	}
+	if limits.MemoryMaxBytes < 0 {
+		return fmt.Errorf("limits.memory_max_bytes must not be negative, got %d", limits.MemoryMaxBytes)
+	}
+	if limits.PidsMax < 0 {
+		return fmt.Errorf("limits.pids_max must not be negative, got %d", limits.PidsMax)
+	}
+	if limits.IOWeight < 0 || limits.IOWeight > 10000 {
+		return fmt.Errorf("limits.io_weight must be 0 or between 1 and 10000, got %d", limits.IOWeight)
+	}
+	return nil
+}
+
+// CancelTaskHandler handles POST requests to cancel every in-flight run of a task on this server,
+// along with its on-demand runs that are still queued.
+func CancelTaskHandler(db *sql.DB, pool *scheduler.WorkerPool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		taskID, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid task ID", http.StatusBadRequest)
+			return
+		}
+
+		cancelled := pool.CancelTask(taskID)
+		queued, err := storage.CancelQueuedTaskRuns(db, taskID, time.Now())
+		if err != nil {
+			http.Error(w, "Failed to cancel queued runs", http.StatusInternalServerError)
+			return
+		}
+		if cancelled == 0 && queued == 0 {
+			http.Error(w, "Task is not running on this server", http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"cancelled_runs":        cancelled,
+			"cancelled_queued_runs": queued,
+			"message":               "Cancellation requested",
+		})
+	}
+}
+
+// CancelRunHandler handles POST requests to cancel a run that is in flight on this server or
+// still queued. A queued run is marked cancelled and skipped when a worker picks it up.
+func CancelRunHandler(db *sql.DB, pool *scheduler.WorkerPool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		runID, err := strconv.ParseInt(mux.Vars(r)["run_id"], 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid run ID", http.StatusBadRequest)
+			return
+		}
+
+		if pool.CancelRun(runID) {
+			w.WriteHeader(http.StatusAccepted)
+			json.NewEncoder(w).Encode(map[string]string{"message": "Cancellation requested"})
+			return
+		}
+
+		queued, err := storage.CancelQueuedTaskRun(db, runID, time.Now())
+		if err != nil {
+			http.Error(w, "Failed to cancel run", http.StatusInternalServerError)
+			return
+		}
+		if !queued {
+			http.Error(w, "Run is not queued or running on this server", http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"message": "Queued run cancelled"})
+	}
+}
+
+// GetRunLogStreamHandler handles GET requests to follow a run's output with Server-Sent Events.
+// While the run is in flight on this server, stdout and stderr lines are sent as "line" events
+// as they are written. Once it has finished, the stored log is sent instead. Either way the
+// stream ends with an "end" event carrying the run's final status. A run recorded as running on
+// this server that is not executing here, e.g. because gronicle crashed during it, is a conflict.
+func GetRunLogStreamHandler(db *sql.DB, logSink storage.LogSink, pool *scheduler.WorkerPool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		runID, err := strconv.ParseInt(mux.Vars(r)["run_id"], 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid run ID", http.StatusBadRequest)
+			return
+		}
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+			return
+		}
+
+		// Wait for a queued run to start, or for a run that just finished to be recorded
+		var live *scheduler.LiveOutput
+		var run *storage.TaskRun
+		untracked := 0
+		for {
+			if live = pool.LiveOutput(runID); live != nil {
+				defer live.Release()
+				break
+			}
+
+			run, err = storage.FetchTaskRunByID(db, runID)
+			if err == sql.ErrNoRows {
+				http.Error(w, "Run not found", http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				http.Error(w, "Failed to fetch run", http.StatusInternalServerError)
+				return
+			}
+			if run.Status != "queued" && run.Status != "running" {
+				break
+			}
+			if run.Host != "" && run.Host != pool.Hostname() {
+				http.Error(w, fmt.Sprintf("Run is in progress on %s", run.Host), http.StatusConflict)
+				return
+			}
+			// A run is recorded as running just before its worker starts tracking it, so only a run
+			// that stays untracked past the next poll was left running by an earlier process
+			if run.Status == "running" && run.Host == pool.Hostname() {
+				if untracked++; untracked > 1 {
+					http.Error(w, "Run is recorded as running but is not executing on this server", http.StatusConflict)
+					return
+				}
+			}
+
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(time.Second):
+			}
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		sendEvent := func(event string, data interface{}) error {
+			payload, err := json.Marshal(data)
+			if err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload); err != nil {
+				return err
+			}
+			flusher.Flush()
+			return nil
+		}
+
+		var status string
+		if live != nil {
+			status, err = live.Follow(r.Context(), func(attempt int, line scheduler.OutputLine) error {
+				return sendEvent("line", map[string]interface{}{"attempt": attempt, "line": line})
+			})
+			if err != nil {
+				return
+			}
+		} else {
+			status = run.Status
+			if run.LogKey != "" {
+				if err := streamStoredLog(logSink, run.LogKey, sendEvent); err != nil {
+					if r.Context().Err() != nil {
+						return
+					}
+					log.Printf("Failed to stream log %s: %v", run.LogKey, err)
+					sendEvent("error", map[string]string{"message": "Failed to fetch stored log"})
+				}
+			}
+		}
+
+		sendEvent("end", map[string]string{"status": status})
+	}
+}
+
+// streamStoredLog sends each line of a stored log as a "line" event, reading the log as it goes
+// rather than loading it whole.
+func streamStoredLog(logSink storage.LogSink, logKey string, sendEvent func(event string, data interface{}) error) error {
+	body, err := logSink.OpenLog(logKey)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	reader := bufio.NewReader(body)
+	for {
+		text, err := reader.ReadString('\n')
+		if text = strings.TrimSuffix(text, "\n"); text != "" {
+			if sendErr := sendEvent("line", map[string]interface{}{"line": scheduler.ParseOutputLine(text)}); sendErr != nil {
+				return sendErr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// maxLogPageSize caps how many logs a single page of GET /logs/{task_id} lists.
+const maxLogPageSize = 1000
+
+// defaultLogPageSize is the page size of the JSON listing when no limit is given.
+const defaultLogPageSize = 100
+
+// LogListing is a page of the JSON listing of a task's logs. NextCursor is empty on the last page.
+type LogListing struct {
+	Logs       []storage.LogObject `json:"logs"`
+	NextCursor string              `json:"next_cursor,omitempty"`
+}
+
+// GetTaskLogsHandler handles GET requests to fetch logs for a specific task from the log sink.
+// By default the logs are concatenated into a plain-text body; ?format=json lists them with their
+// sizes and timestamps instead. ?limit= and ?cursor= page through the logs in key order, and
+// ?since= and ?until= (RFC 3339) keep only logs last modified within that window.
+func GetTaskLogsHandler(logSink storage.LogSink) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		taskID := mux.Vars(r)["task_id"]
-		prefix := fmt.Sprintf("logs/%s/", taskID)
+		taskID, err := strconv.Atoi(mux.Vars(r)["task_id"])
+		if err != nil {
+			http.Error(w, "Invalid task ID", http.StatusBadRequest)
+			return
+		}
+		prefix := storage.TaskLogPrefix(taskID)
+		query := r.URL.Query()
+		asJSON := query.Get("format") == "json"
+
+		limit := 0
+		if asJSON {
+			limit = defaultLogPageSize
+		}
+		if value := query.Get("limit"); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed < 1 || parsed > maxLogPageSize {
+				http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxLogPageSize), http.StatusBadRequest)
+				return
+			}
+			limit = parsed
+		}
+
+		since, err := parseTimeParam(query.Get("since"))
+		if err != nil {
+			http.Error(w, "since must be an RFC 3339 timestamp", http.StatusBadRequest)
+			return
+		}
+		until, err := parseTimeParam(query.Get("until"))
+		if err != nil {
+			http.Error(w, "until must be an RFC 3339 timestamp", http.StatusBadRequest)
+			return
+		}
+
+		cursor := query.Get("cursor")
+		if cursor != "" && !strings.HasPrefix(cursor, prefix) {
+			http.Error(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
 
-		// List objects in S3 with the task-specific prefix
-		logFiles, err := s3Logger.ListLogFiles(prefix)
+		// List logs with the task-specific prefix
+		logObjects, nextCursor, err := listLogPage(logSink, prefix, cursor, limit, since, until)
 		if err != nil {
 			http.Error(w, "Failed to retrieve logs", http.StatusInternalServerError)
 			return
 		}
 
-		if len(logFiles) == 0 {
+		if asJSON {
+			if logObjects == nil {
+				logObjects = []storage.LogObject{}
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(LogListing{Logs: logObjects, NextCursor: nextCursor})
+			return
+		}
+
+		if len(logObjects) == 0 {
 			http.Error(w, "No logs found for this task", http.StatusNotFound)
 			return
 		}
 
-		// Combine log entries into a single response
-		var allLogs []string
-		for _, file := range logFiles {
-			logContent, err := s3Logger.FetchLogContent(file)
+		// Stream the log entries one after another, so large logs are never held in memory
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if nextCursor != "" {
+			w.Header().Set("X-Next-Cursor", nextCursor)
+		}
+		w.WriteHeader(http.StatusOK)
+		separator := ""
+		for _, object := range logObjects {
+			body, err := logSink.OpenLog(object.Key)
 			if err != nil {
-				log.Printf("Failed to fetch log content from %s: %v", file, err)
+				log.Printf("Failed to fetch log content from %s: %v", object.Key, err)
 				continue
 			}
-			allLogs = append(allLogs, fmt.Sprintf("Log from %s:\n%s", file, logContent))
+
+			fmt.Fprintf(w, "%sLog from %s:\n", separator, object.Key)
+			if _, err := io.Copy(w, body); err != nil {
+				log.Printf("Failed to stream log content from %s: %v", object.Key, err)
+			}
+			body.Close()
+			separator = "\n\n"
 		}
+	}
+}
+
+// listLogPage lists up to limit logs under prefix that sort after cursor and were last modified
+// within [since, until], and returns the cursor of the next page, or "" if there are no more logs.
+// A limit of 0 lists every matching log. Zero since and until times leave that end of the window open.
+func listLogPage(logSink storage.LogSink, prefix, cursor string, limit int, since, until time.Time) ([]storage.LogObject, string, error) {
+	var page []storage.LogObject
+	for {
+		batch, err := logSink.ListLogObjects(prefix, cursor, limit)
+		if err != nil {
+			return nil, "", err
+		}
+
+		for _, object := range batch {
+			cursor = object.Key
+			if !since.IsZero() && object.LastModified.Before(since) {
+				continue
+			}
+			if !until.IsZero() && object.LastModified.After(until) {
+				continue
+			}
 
+			page = append(page, object)
+			if limit > 0 && len(page) == limit {
+				// Only hand out a cursor if there is something after it
+				more, err := logSink.ListLogObjects(prefix, cursor, 1)
+				if err != nil || len(more) == 0 {
+					return page, "", err
+				}
+				return page, cursor, nil
+			}
+		}
+
+		// A short batch means the listing is exhausted
+		if limit <= 0 || len(batch) < limit {
+			return page, "", nil
+		}
+	}
+}
+
+// parseTimeParam parses an optional RFC 3339 query parameter, returning the zero time if it is empty.
+func parseTimeParam(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// parseRangeParam parses an inclusive range such as "100-199" or an open-ended one such as "100-".
+func parseRangeParam(value string) (start, end int64, err error) {
+	first, last, ok := strings.Cut(value, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("range %q must look like start-end or start-", value)
+	}
+	if start, err = strconv.ParseInt(first, 10, 64); err != nil || start < 0 {
+		return 0, 0, fmt.Errorf("invalid range start %q", first)
+	}
+
+	end = -1
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+			return 0, 0, fmt.Errorf("invalid range end %q", last)
+		}
+	}
+	return start, end, nil
+}
+
+// GetRunLogHandler handles GET requests to read the stored log of a run, or of one of its attempts
+// with ?attempt=. ?bytes=start-end returns a byte range of the log, counting from 0, and
+// ?lines=start-end a range of lines, counting from 1. Both ranges are inclusive and may leave out the end.
+// ?stream=stdout or ?stream=stderr returns only the text the command wrote to that stream, without
+// timestamps; ?lines= then counts the lines of that stream.
+func GetRunLogHandler(db *sql.DB, logSink storage.LogSink) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		runID, err := strconv.ParseInt(mux.Vars(r)["run_id"], 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid run ID", http.StatusBadRequest)
+			return
+		}
+		query := r.URL.Query()
+
+		if query.Get("bytes") != "" && query.Get("lines") != "" {
+			http.Error(w, "Use either bytes or lines, not both", http.StatusBadRequest)
+			return
+		}
+		stream := query.Get("stream")
+		if stream != "" && stream != scheduler.StreamStdout && stream != scheduler.StreamStderr {
+			http.Error(w, "stream must be stdout or stderr", http.StatusBadRequest)
+			return
+		}
+		if stream != "" && query.Get("bytes") != "" {
+			http.Error(w, "bytes counts the whole log and cannot be combined with stream", http.StatusBadRequest)
+			return
+		}
+
+		run, err := storage.FetchTaskRunByID(db, runID)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Run not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to fetch run", http.StatusInternalServerError)
+			return
+		}
+
+		logKey := run.LogKey
+		if value := query.Get("attempt"); value != "" {
+			attemptNumber, err := strconv.Atoi(value)
+			if err != nil {
+				http.Error(w, "Invalid attempt", http.StatusBadRequest)
+				return
+			}
+
+			logKey = ""
+			for _, attempt := range run.Attempts {
+				if attempt.Attempt == attemptNumber {
+					logKey = attempt.LogKey
+				}
+			}
+		}
+		if logKey == "" {
+			http.Error(w, "No log stored for this run", http.StatusNotFound)
+			return
+		}
+
+		offset, length := int64(0), int64(-1)
+		if value := query.Get("bytes"); value != "" {
+			start, end, err := parseRangeParam(value)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			offset = start
+			if end >= 0 {
+				length = end - start + 1
+			}
+		}
+
+		firstLine, lastLine := int64(0), int64(-1)
+		if value := query.Get("lines"); value != "" {
+			start, end, err := parseRangeParam(value)
+			if err != nil || start < 1 {
+				http.Error(w, "lines must look like start-end or start-, counting from 1", http.StatusBadRequest)
+				return
+			}
+			firstLine, lastLine = start, end
+		}
+
+		stored, err := logSink.OpenLogRange(logKey, offset, length)
+		if err != nil {
+			http.Error(w, "Failed to fetch log", http.StatusInternalServerError)
+			return
+		}
+		defer stored.Close()
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("X-Log-Key", logKey)
 		w.WriteHeader(http.StatusOK)
-		w.Write([]byte(strings.Join(allLogs, "\n\n")))
+
+		var body io.Reader = stored
+		if stream != "" {
+			reader, writer := io.Pipe()
+			go func() {
+				writer.CloseWithError(copyStream(writer, stored, stream))
+			}()
+			defer reader.Close()
+			body = reader
+		}
+
+		if firstLine == 0 {
+			if _, err := io.Copy(w, body); err != nil {
+				log.Printf("Failed to stream log %s: %v", logKey, err)
+			}
+			return
+		}
+
+		if err := copyLines(w, body, firstLine, lastLine); err != nil {
+			log.Printf("Failed to stream log %s: %v", logKey, err)
+		}
+	}
+}
+
+// copyLines copies lines first through last of r to w, counting from 1. A negative last copies to the end.
+func copyLines(w io.Writer, r io.Reader, first, last int64) error {
+	reader := bufio.NewReader(r)
+	for number := int64(1); last < 0 || number <= last; number++ {
+		line, err := reader.ReadSlice('\n')
+		for err == bufio.ErrBufferFull {
+			// A line longer than the buffer arrives in pieces
+			if number >= first {
+				if _, writeErr := w.Write(line); writeErr != nil {
+					return writeErr
+				}
+			}
+			line, err = reader.ReadSlice('\n')
+		}
+
+		if number >= first {
+			if _, writeErr := w.Write(line); writeErr != nil {
+				return writeErr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyStream copies the text of the lines of a stored log that were written to stream to w, one
+// per line. Lines without a stream, such as the exit summary, are left out.
+func copyStream(w io.Writer, r io.Reader, stream string) error {
+	reader := bufio.NewReader(r)
+	for {
+		text, err := reader.ReadString('\n')
+		if text != "" {
+			if line := scheduler.ParseOutputLine(strings.TrimSuffix(text, "\n")); line.Stream == stream {
+				if _, writeErr := io.WriteString(w, line.Text+"\n"); writeErr != nil {
+					return writeErr
+				}
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
 	}
 }
 
-// GetFailedLogsHandler lists locally stored logs for failed tasks.
+// GetFailedLogsHandler lists the keys of logs stored locally because they could not be uploaded to S3.
 func GetFailedLogsHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		files, err := os.ReadDir("local_logs")
+		logFiles, err := storage.ListLocalLogKeys(storage.LocalFallbackDir)
 		if err != nil {
 			http.Error(w, "Failed to read local logs", http.StatusInternalServerError)
 			return
 		}
 
-		var logFiles []string
-		for _, file := range files {
-			logFiles = append(logFiles, file.Name())
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(logFiles)
+	}
+}
+
+// GetFailedLogsBacklogHandler reports how many locally stored logs are waiting to be replayed to S3
+// and how old the oldest one is.
+func GetFailedLogsBacklogHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		backlog, err := storage.FetchLocalLogBacklog(storage.LocalFallbackDir)
+		if err != nil {
+			http.Error(w, "Failed to read local logs", http.StatusInternalServerError)
+			return
 		}
 
 		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(logFiles)
+		json.NewEncoder(w).Encode(backlog)
 	}
 }
 
@@ -162,8 +894,9 @@ func GetTaskMetricsHandler(db *sql.DB) http.HandlerFunc {
 	}
 }
 
-// GetEnhancedMetricsHandler handles GET requests to fetch enhanced metrics.
-func GetEnhancedMetricsHandler(db *sql.DB) http.HandlerFunc {
+// GetEnhancedMetricsHandler handles GET requests to fetch enhanced metrics, including how many
+// task metrics rows this server dropped because MySQL could not keep up.
+func GetEnhancedMetricsHandler(db *sql.DB, pool *scheduler.WorkerPool) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// Fetch basic and enhanced metrics
 		basicMetrics, err := storage.FetchTaskMetrics(db)
@@ -177,6 +910,7 @@ func GetEnhancedMetricsHandler(db *sql.DB) http.HandlerFunc {
 			http.Error(w, "Failed to fetch enhanced metrics", http.StatusInternalServerError)
 			return
 		}
+		enhancedMetrics["metrics_rows_dropped"] = pool.MetricsDropped()
 
 		// Combine basic and enhanced metrics
 		response := map[string]interface{}{
@@ -189,7 +923,10 @@ func GetEnhancedMetricsHandler(db *sql.DB) http.HandlerFunc {
 	}
 }
 
-// GetTaskMetricsHandlerV2 handles GET requests to fetch all metrics for a task (system + process).
+// GetTaskMetricsHandlerV2 handles GET requests to fetch the metrics of a task: system snapshots
+// taken around its attempts and samples of its process tree taken while they ran. ?kind= selects
+// "system" or "process" metrics, ?phase= system snapshots taken "pre", "post" or "failure", and
+// ?run_id= and ?attempt= those of one run or attempt.
 func GetTaskMetricsHandlerV2(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		taskID, err := strconv.Atoi(mux.Vars(r)["task_id"])
@@ -197,9 +934,40 @@ func GetTaskMetricsHandlerV2(db *sql.DB) http.HandlerFunc {
 			http.Error(w, "Invalid task ID", http.StatusBadRequest)
 			return
 		}
+		query := r.URL.Query()
+
+		filter := storage.TaskMetricsFilter{Kind: query.Get("kind"), Phase: query.Get("phase")}
+		switch filter.Kind {
+		case "", storage.MetricsKindSystem, storage.MetricsKindProcess:
+		default:
+			http.Error(w, "kind must be system or process", http.StatusBadRequest)
+			return
+		}
+		switch filter.Phase {
+		case "":
+		case storage.MetricsPhasePre, storage.MetricsPhasePost, storage.MetricsPhaseFailure:
+			if filter.Kind == storage.MetricsKindProcess {
+				http.Error(w, "phase only applies to system metrics", http.StatusBadRequest)
+				return
+			}
+		default:
+			http.Error(w, "phase must be pre, post or failure", http.StatusBadRequest)
+			return
+		}
+		if value := query.Get("run_id"); value != "" {
+			if filter.RunID, err = strconv.ParseInt(value, 10, 64); err != nil || filter.RunID < 1 {
+				http.Error(w, "Invalid run ID", http.StatusBadRequest)
+				return
+			}
+		}
+		if value := query.Get("attempt"); value != "" {
+			if filter.Attempt, err = strconv.Atoi(value); err != nil || filter.Attempt < 1 {
+				http.Error(w, "Invalid attempt", http.StatusBadRequest)
+				return
+			}
+		}
 
-		// Fetch system metrics and per-process metrics
-		metrics, err := storage.FetchTaskMetricsV2(db, taskID)
+		metrics, err := storage.FetchTaskMetricsV2(db, taskID, filter)
 		if err != nil {
 			http.Error(w, "Failed to fetch task metrics", http.StatusInternalServerError)
 			return
@@ -211,17 +979,25 @@ func GetTaskMetricsHandlerV2(db *sql.DB) http.HandlerFunc {
 }
 
 // InitializeRouter sets up API routes.
-func InitializeRouter(db *sql.DB, s3Logger *storage.S3Logger) *mux.Router {
+func InitializeRouter(db *sql.DB, logSink storage.LogSink, pool *scheduler.WorkerPool) *mux.Router {
 	router := mux.NewRouter()
 
 	router.HandleFunc("/tasks", AddTaskHandler(db)).Methods("POST")
 	router.HandleFunc("/tasks", GetTasksHandler(db)).Methods("GET")
 	router.HandleFunc("/tasks/{id:[0-9]+}", GetTaskByIDHandler(db)).Methods("GET")
 	router.HandleFunc("/tasks/{id:[0-9]+}", DeleteTaskHandler(db)).Methods("DELETE")
-	router.HandleFunc("/logs/{task_id}", GetTaskLogsHandler(s3Logger)).Methods("GET")
+	router.HandleFunc("/tasks/{id:[0-9]+}/runs", GetTaskRunsHandler(db)).Methods("GET")
+	router.HandleFunc("/runs/{run_id:[0-9]+}", GetRunByIDHandler(db)).Methods("GET")
+	router.HandleFunc("/tasks/{id:[0-9]+}/run", RunTaskNowHandler(db, pool)).Methods("POST")
+	router.HandleFunc("/tasks/{id:[0-9]+}/cancel", CancelTaskHandler(db, pool)).Methods("POST")
+	router.HandleFunc("/runs/{run_id:[0-9]+}/cancel", CancelRunHandler(db, pool)).Methods("POST")
+	router.HandleFunc("/runs/{run_id:[0-9]+}/logs/stream", GetRunLogStreamHandler(db, logSink, pool)).Methods("GET")
+	router.HandleFunc("/runs/{run_id:[0-9]+}/logs", GetRunLogHandler(db, logSink)).Methods("GET")
+	router.HandleFunc("/logs/{task_id:[0-9]+}", GetTaskLogsHandler(logSink)).Methods("GET")
 	router.HandleFunc("/failed_logs", GetFailedLogsHandler()).Methods("GET")
+	router.HandleFunc("/failed_logs/backlog", GetFailedLogsBacklogHandler()).Methods("GET")
 	router.HandleFunc("/metrics", GetTaskMetricsHandler(db)).Methods("GET") // New endpoint for metrics
-	router.HandleFunc("/metrics/enhanced", GetEnhancedMetricsHandler(db)).Methods("GET")
+	router.HandleFunc("/metrics/enhanced", GetEnhancedMetricsHandler(db, pool)).Methods("GET")
 	router.HandleFunc("/tasks/{task_id:[0-9]+}/metrics", GetTaskMetricsHandlerV2(db)).Methods("GET")
 	return router
 }
@@ -0,0 +1,180 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shammishailaj/gronicle/pkg/storage"
+)
+
+func TestListLogPage(t *testing.T) {
+	dir := t.TempDir()
+	sink := storage.NewLocalLogSink(dir)
+
+	// Odd runs were stored in June, even ones in January
+	january := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	june := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	for run := 1; run <= 7; run++ {
+		key := storage.RunLogKey(1, fmt.Sprintf("%02d", run), 1)
+		if err := sink.UploadLog(key, strings.NewReader("output\n")); err != nil {
+			t.Fatal(err)
+		}
+		modified := january
+		if run%2 == 1 {
+			modified = june
+		}
+		if err := os.Chtimes(filepath.Join(dir, filepath.FromSlash(key)), modified, modified); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Another task's log must never be listed
+	if err := sink.UploadLog(storage.RunLogKey(10, "01", 1), strings.NewReader("other\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	key := func(run int) string { return storage.RunLogKey(1, fmt.Sprintf("%02d", run), 1) }
+	march := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name         string
+		limit        int
+		since, until time.Time
+		wantPages    [][]string
+	}{
+		{
+			name:      "no limit lists everything",
+			wantPages: [][]string{{key(1), key(2), key(3), key(4), key(5), key(6), key(7)}},
+		},
+		{
+			name:      "pages in key order",
+			limit:     3,
+			wantPages: [][]string{{key(1), key(2), key(3)}, {key(4), key(5), key(6)}, {key(7)}},
+		},
+		{
+			name:      "a full last page has no cursor",
+			limit:     7,
+			wantPages: [][]string{{key(1), key(2), key(3), key(4), key(5), key(6), key(7)}},
+		},
+		{
+			name:      "since fills pages with matching logs only",
+			limit:     2,
+			since:     march,
+			wantPages: [][]string{{key(1), key(3)}, {key(5), key(7)}},
+		},
+		{
+			name:      "until",
+			until:     march,
+			wantPages: [][]string{{key(2), key(4), key(6)}},
+		},
+		{
+			name:      "window matching nothing",
+			limit:     2,
+			since:     june.Add(time.Hour),
+			wantPages: [][]string{nil},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var pages [][]string
+			cursor := ""
+			for {
+				objects, next, err := listLogPage(sink, storage.TaskLogPrefix(1), cursor, tt.limit, tt.since, tt.until)
+				if err != nil {
+					t.Fatalf("listLogPage: %v", err)
+				}
+
+				var page []string
+				for _, object := range objects {
+					page = append(page, object.Key)
+				}
+				pages = append(pages, page)
+
+				if next == "" {
+					break
+				}
+				if len(pages) > len(tt.wantPages) {
+					t.Fatalf("got more pages than expected: %v", pages)
+				}
+				cursor = next
+			}
+
+			if !reflect.DeepEqual(pages, tt.wantPages) {
+				t.Errorf("pages = %v, want %v", pages, tt.wantPages)
+			}
+		})
+	}
+}
+
+func TestParseRangeParam(t *testing.T) {
+	tests := []struct {
+		value     string
+		wantStart int64
+		wantEnd   int64
+		wantErr   bool
+	}{
+		{value: "0-99", wantStart: 0, wantEnd: 99},
+		{value: "100-", wantStart: 100, wantEnd: -1},
+		{value: "5-5", wantStart: 5, wantEnd: 5},
+		{value: "", wantErr: true},
+		{value: "5", wantErr: true},
+		{value: "-5", wantErr: true},
+		{value: "10-5", wantErr: true},
+		{value: "x-5", wantErr: true},
+		{value: "1-x", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			start, end, err := parseRangeParam(tt.value)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("parseRangeParam(%q) = %d, %d, want an error", tt.value, start, end)
+				}
+				return
+			}
+			if err != nil || start != tt.wantStart || end != tt.wantEnd {
+				t.Errorf("parseRangeParam(%q) = %d, %d, %v, want %d, %d", tt.value, start, end, err, tt.wantStart, tt.wantEnd)
+			}
+		})
+	}
+}
+
+func TestCopyLines(t *testing.T) {
+	// Longer than bufio's default buffer, so it is read in pieces
+	long := strings.Repeat("x", 10000)
+
+	tests := []struct {
+		name        string
+		input       string
+		first, last int64
+		want        string
+	}{
+		{name: "all lines", input: "one\ntwo\nthree\n", first: 1, last: -1, want: "one\ntwo\nthree\n"},
+		{name: "middle line", input: "one\ntwo\nthree\n", first: 2, last: 2, want: "two\n"},
+		{name: "to the end", input: "one\ntwo\nthree\n", first: 2, last: -1, want: "two\nthree\n"},
+		{name: "unterminated last line", input: "one\ntwo", first: 2, last: 5, want: "two"},
+		{name: "past the end", input: "one\ntwo\n", first: 5, last: -1, want: ""},
+		{name: "long line", input: "one\n" + long + "\nthree\n", first: 2, last: 2, want: long + "\n"},
+		{name: "long line counts once", input: "one\n" + long + "\nthree\n", first: 3, last: -1, want: "three\n"},
+		{name: "long unterminated line", input: "one\n" + long, first: 2, last: -1, want: long},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := copyLines(&out, strings.NewReader(tt.input), tt.first, tt.last); err != nil {
+				t.Fatalf("copyLines: %v", err)
+			}
+			if got := out.String(); got != tt.want {
+				t.Errorf("copyLines(%d, %d) = %.40q (%d bytes), want %.40q (%d bytes)", tt.first, tt.last, got, len(got), tt.want, len(tt.want))
+			}
+		})
+	}
+}
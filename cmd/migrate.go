@@ -0,0 +1,66 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/shammishailaj/gronicle/migrations"
+	"github.com/shammishailaj/gronicle/pkg/storage"
+)
+
+const migrateUsage = "usage: gronicle [--config file]... migrate up | down [steps] | status"
+
+// runMigrate implements the "gronicle migrate" subcommand.
+func runMigrate(db *sql.DB, args []string) {
+	if len(args) == 0 {
+		log.Fatal(migrateUsage)
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := storage.MigrateUp(db, migrations.FS)
+		if err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		log.Printf("Applied %d migration(s)", len(applied))
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			var err error
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				log.Fatalf("Invalid number of steps %q: %s", args[1], migrateUsage)
+			}
+		}
+
+		rolledBack, err := storage.MigrateDown(db, migrations.FS, steps)
+		if err != nil {
+			log.Fatalf("Rollback failed: %v", err)
+		}
+		log.Printf("Rolled back %d migration(s)", len(rolledBack))
+
+	case "status":
+		statuses, err := storage.FetchMigrationStatus(db, migrations.FS)
+		if err != nil {
+			log.Fatalf("Failed to fetch migration status: %v", err)
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED AT")
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.AppliedAt != nil {
+				appliedAt = status.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(tw, "%03d\t%s\t%s\n", status.Version, status.Name, appliedAt)
+		}
+		tw.Flush()
+
+	default:
+		log.Fatal(migrateUsage)
+	}
+}
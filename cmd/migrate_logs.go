@@ -0,0 +1,54 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/shammishailaj/gronicle/pkg/storage"
+)
+
+const migrateLogsUsage = "usage: gronicle [--config file]... migrate-logs [--dry-run]"
+
+// runMigrateLogs implements the "gronicle migrate-logs" subcommand, which moves run logs and failed
+// attempt summaries stored under job names to keys built from task and run IDs.
+func runMigrateLogs(db *sql.DB, logSink storage.LogSink, args []string) {
+	dryRun := false
+	for _, arg := range args {
+		if arg != "--dry-run" {
+			log.Fatal(migrateLogsUsage)
+		}
+		dryRun = true
+	}
+
+	migration, err := storage.MigrateLogKeys(db, logSink, dryRun)
+	if migration != nil {
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "FROM\tTO")
+		for _, move := range migration.Moved {
+			fmt.Fprintf(tw, "%s\t%s\n", move.From, move.To)
+		}
+
+		skipped := make([]string, 0, len(migration.Skipped))
+		for key := range migration.Skipped {
+			skipped = append(skipped, key)
+		}
+		sort.Strings(skipped)
+		for _, key := range skipped {
+			fmt.Fprintf(tw, "%s\tskipped: %s\n", key, migration.Skipped[key])
+		}
+		tw.Flush()
+	}
+	if err != nil {
+		log.Fatalf("Log migration failed: %v", err)
+	}
+
+	if dryRun {
+		log.Printf("Would move %d log(s), skipping %d", len(migration.Moved), len(migration.Skipped))
+		return
+	}
+	log.Printf("Moved %d log(s), skipped %d", len(migration.Moved), len(migration.Skipped))
+}
@@ -1,35 +1,109 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"github.com/shammishailaj/gronicle/api"
 	"log"
+	"net"
 	"net/http"
-	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
+	"github.com/shammishailaj/gronicle/migrations"
+	"github.com/shammishailaj/gronicle/pkg/config"
 	"github.com/shammishailaj/gronicle/pkg/scheduler"
 	"github.com/shammishailaj/gronicle/pkg/storage"
 )
 
+// defaultConfigFiles are loaded when no --config flag is given.
+var defaultConfigFiles = []string{
+	"config/mysql_config.yaml",
+	"config/s3_config.yaml",
+	"config/server_config.yaml",
+}
+
+// configFiles collects repeated --config flags.
+type configFiles []string
+
+func (c *configFiles) String() string {
+	return strings.Join(*c, ",")
+}
+
+func (c *configFiles) Set(value string) error {
+	*c = append(*c, value)
+	return nil
+}
+
 func main() {
-	serverPort := os.Getenv("SERVER_PORT")
-	if serverPort == "" {
-		serverPort = "9999"
+	var files configFiles
+	flag.Var(&files, "config", "YAML config file; may be repeated, later files override earlier ones (default "+strings.Join(defaultConfigFiles, ", ")+")")
+	flag.Parse()
+
+	if len(files) == 0 {
+		files = defaultConfigFiles
+	}
+
+	cfg, err := config.Load(files...)
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
 	}
-	log.Println("Starting Gronicle Server...")
 
 	// Connect to MySQL
-	db := storage.ConnectMySQL("scalland", "scallandpass", "localhost:3306", "gronicle")
+	db := storage.ConnectMySQL(cfg.MySQL.User, cfg.MySQL.Password, cfg.MySQL.Address(), cfg.MySQL.Database)
 	defer db.Close()
 
-	// Initialize the S3 logger
-	s3Logger := storage.NewS3Logger("gronicle-logs", "ap-south-1")
+	if flag.Arg(0) == "migrate" {
+		runMigrate(db, flag.Args()[1:])
+		return
+	}
+
+	// Bring the schema up to date before anything queries it
+	if _, err := storage.MigrateUp(db, migrations.FS); err != nil {
+		log.Fatalf("Failed to migrate database: %v", err)
+	}
+
+	// Initialize the log sink
+	logSink := newLogSink(cfg)
 
-	// Initialize the scheduler with 5 workers, 3 retry attempts, and a 10-second polling interval
-	s := scheduler.NewSchedulerWithDB(db, 5, 3, 10*time.Second)
+	if flag.Arg(0) == "migrate-logs" {
+		runMigrateLogs(db, logSink, flag.Args()[1:])
+		return
+	}
 
-	// Initialize the worker pool with the S3 logger
-	s.WorkerPool = scheduler.NewWorkerPool(5, 3, s3Logger)
+	log.Println("Starting Gronicle Server...")
+
+	// Mask secrets in task output before it is stored, streamed or logged
+	redactPatterns, err := scheduler.CompileRedactPatterns(cfg.Redaction.AllPatterns())
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	redactor := scheduler.NewRedactor(nil, redactPatterns)
+
+	// Store task metrics in batches in the background. Deferred, so it is stopped after the scheduler
+	// and stores the metrics of runs that finish while draining.
+	metricsWriter := storage.NewMetricsWriter(db, storage.MetricsWriterOptions{
+		BatchSize:     cfg.Metrics.BatchSize,
+		FlushInterval: cfg.Metrics.FlushInterval,
+		QueueSize:     cfg.Metrics.QueueSize,
+	})
+	metricsWriter.Start()
+	defer metricsWriter.Stop()
+
+	// Initialize the scheduler with the configured workers, retry attempts, polling interval, log sink and redaction
+	s := scheduler.NewSchedulerWithDB(db, cfg.Scheduler.Workers, cfg.Scheduler.RetryLimit, cfg.Scheduler.PollInterval, logSink, redactor, cfg.Scheduler.CgroupParent, metricsWriter,
+		scheduler.SamplerOptions{Interval: cfg.Metrics.SampleInterval, MaxSamples: cfg.Metrics.MaxSamples})
+
+	// Replay logs that an S3 outage left in local_logs
+	if s3Logger, ok := logSink.(*storage.S3Logger); ok {
+		replayer := storage.NewLogReplayer(s3Logger, cfg.Logs.ReplayInterval)
+		replayer.Start()
+		defer replayer.Stop()
+	}
 
 	// Start polling for new tasks
 	s.LoadTasksFromDB()
@@ -38,11 +112,56 @@ func main() {
 	s.Start(db)
 
 	// Set up the API server
-	router := api.InitializeRouter(db, s3Logger)
+	router := api.InitializeRouter(db, logSink, s.WorkerPool)
+	// Requests run under baseCtx, which is cancelled when shutdown begins so that long-lived
+	// requests such as log streams end instead of holding up the shutdown
+	baseCtx, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
+	server := &http.Server{
+		Addr:        fmt.Sprintf(":%d", cfg.Server.Port),
+		Handler:     router,
+		BaseContext: func(net.Listener) context.Context { return baseCtx },
+	}
+	server.RegisterOnShutdown(cancelRequests)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
-	log.Println("Starting API server on port %s...", serverPort)
-	log.Fatal(http.ListenAndServe(":"+serverPort, router))
+	go func() {
+		log.Printf("Starting API server on port %d...", cfg.Server.Port)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("API server failed: %v", err)
+		}
+	}()
+
+	<-ctx.Done()
+	log.Println("Shutting down Gronicle Server...")
+
+	// Stop claiming tasks first so nothing new starts while the rest shuts down
+	s.StopPolling()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("API server did not shut down cleanly: %v", err)
+	}
+
+	s.Stop(cfg.Scheduler.DrainTimeout)
+	log.Println("Gronicle Server stopped.")
+}
+
+// newLogSink creates the log sink selected by the configuration.
+func newLogSink(cfg *config.Config) storage.LogSink {
+	if cfg.Logs.Sink == config.LogSinkLocal {
+		log.Printf("Storing task logs locally in %s", cfg.Logs.LocalDir)
+		return storage.NewLocalLogSink(cfg.Logs.LocalDir)
+	}
 
-	// Keep the main process running
-	select {}
+	return storage.NewS3LoggerWithOptions(storage.S3Options{
+		Bucket:       cfg.S3.Bucket,
+		Region:       cfg.S3.Region,
+		Endpoint:     cfg.S3.Endpoint,
+		UsePathStyle: cfg.S3.UsePathStyle,
+		Compress:     cfg.S3.Compress,
+	})
 }
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS task_metrics;
+
+DROP TABLE IF EXISTS logs;
+
+DROP TABLE IF EXISTS tasks;
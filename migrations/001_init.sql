@@ -1,16 +0,0 @@
-CREATE TABLE tasks (
-    id INT AUTO_INCREMENT PRIMARY KEY,
-    job_name VARCHAR(255) NOT NULL,
-    status ENUM('pending', 'running', 'failed', 'completed') DEFAULT 'pending',
-    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
-);
-
-CREATE TABLE logs (
-    id INT AUTO_INCREMENT PRIMARY KEY,
-    task_id INT NOT NULL,
-    log_message TEXT,
-    log_level ENUM('INFO', 'ERROR', 'DEBUG') DEFAULT 'INFO',
-    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-    FOREIGN KEY (task_id) REFERENCES tasks(id) ON DELETE CASCADE
-);
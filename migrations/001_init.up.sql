@@ -0,0 +1,34 @@
+-- IF NOT EXISTS lets databases that were set up by hand before migrations existed adopt them.
+CREATE TABLE IF NOT EXISTS tasks (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    job_name VARCHAR(255) NOT NULL,
+    command TEXT NOT NULL,
+    interval_seconds INT NOT NULL DEFAULT 0,
+    status ENUM('pending', 'running', 'failed', 'completed') DEFAULT 'pending',
+    start_time TIMESTAMP NULL DEFAULT NULL,
+    end_time TIMESTAMP NULL DEFAULT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS logs (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    task_id INT NOT NULL,
+    log_message TEXT,
+    log_level ENUM('INFO', 'ERROR', 'DEBUG') DEFAULT 'INFO',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (task_id) REFERENCES tasks(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS task_metrics (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    task_id INT NOT NULL,
+    cpu_usage DOUBLE NOT NULL DEFAULT 0,
+    ram_usage DOUBLE NOT NULL DEFAULT 0,
+    disk_usage DOUBLE NOT NULL DEFAULT 0,
+    load_average DOUBLE NOT NULL DEFAULT 0,
+    gpu_usage DOUBLE NOT NULL DEFAULT 0,
+    recorded_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_task_metrics_task_id (task_id),
+    FOREIGN KEY (task_id) REFERENCES tasks(id) ON DELETE CASCADE
+);
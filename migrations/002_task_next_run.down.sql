@@ -0,0 +1,3 @@
+DROP INDEX idx_tasks_next_run_at ON tasks;
+
+ALTER TABLE tasks DROP COLUMN next_run_at;
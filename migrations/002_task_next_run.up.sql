@@ -0,0 +1,5 @@
+ALTER TABLE tasks ADD COLUMN next_run_at TIMESTAMP NULL DEFAULT NULL;
+
+UPDATE tasks SET next_run_at = CURRENT_TIMESTAMP WHERE status IN ('pending', 'running');
+
+CREATE INDEX idx_tasks_next_run_at ON tasks (next_run_at);
@@ -0,0 +1,3 @@
+ALTER TABLE tasks DROP COLUMN timezone;
+
+ALTER TABLE tasks DROP COLUMN schedule;
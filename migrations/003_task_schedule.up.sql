@@ -0,0 +1,3 @@
+ALTER TABLE tasks ADD COLUMN schedule VARCHAR(255) NOT NULL DEFAULT '';
+
+ALTER TABLE tasks ADD COLUMN timezone VARCHAR(64) NOT NULL DEFAULT 'UTC';
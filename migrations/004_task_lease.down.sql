@@ -0,0 +1,3 @@
+ALTER TABLE tasks DROP COLUMN lease_expires_at;
+
+ALTER TABLE tasks DROP COLUMN lease_owner;
@@ -0,0 +1,3 @@
+ALTER TABLE tasks ADD COLUMN lease_owner VARCHAR(255) NULL DEFAULT NULL;
+
+ALTER TABLE tasks ADD COLUMN lease_expires_at TIMESTAMP NULL DEFAULT NULL;
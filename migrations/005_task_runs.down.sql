@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS task_run_attempts;
+
+DROP TABLE IF EXISTS task_runs;
@@ -0,0 +1,25 @@
+CREATE TABLE task_runs (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    task_id INT NOT NULL,
+    status VARCHAR(32) NOT NULL DEFAULT 'running',
+    worker_id INT NOT NULL,
+    host VARCHAR(255) NOT NULL,
+    log_key VARCHAR(1024) NOT NULL DEFAULT '',
+    start_time TIMESTAMP NULL DEFAULT NULL,
+    end_time TIMESTAMP NULL DEFAULT NULL,
+    INDEX idx_task_runs_task_id (task_id),
+    FOREIGN KEY (task_id) REFERENCES tasks(id) ON DELETE CASCADE
+);
+
+CREATE TABLE task_run_attempts (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    run_id BIGINT NOT NULL,
+    attempt INT NOT NULL,
+    status VARCHAR(32) NOT NULL DEFAULT 'running',
+    exit_code INT NULL DEFAULT NULL,
+    log_key VARCHAR(1024) NOT NULL DEFAULT '',
+    start_time TIMESTAMP NULL DEFAULT NULL,
+    end_time TIMESTAMP NULL DEFAULT NULL,
+    UNIQUE KEY uq_task_run_attempts_run_attempt (run_id, attempt),
+    FOREIGN KEY (run_id) REFERENCES task_runs(id) ON DELETE CASCADE
+);
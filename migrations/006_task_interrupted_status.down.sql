@@ -0,0 +1,3 @@
+UPDATE tasks SET status = 'failed' WHERE status = 'interrupted';
+
+ALTER TABLE tasks MODIFY COLUMN status ENUM('pending', 'running', 'failed', 'completed') DEFAULT 'pending';
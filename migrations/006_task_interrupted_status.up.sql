@@ -0,0 +1 @@
+ALTER TABLE tasks MODIFY COLUMN status ENUM('pending', 'running', 'failed', 'completed', 'interrupted') DEFAULT 'pending';
@@ -0,0 +1,5 @@
+UPDATE tasks SET status = 'failed' WHERE status = 'timed_out';
+
+ALTER TABLE tasks MODIFY COLUMN status ENUM('pending', 'running', 'failed', 'completed', 'interrupted') DEFAULT 'pending';
+
+ALTER TABLE tasks DROP COLUMN timeout_seconds;
@@ -0,0 +1,3 @@
+ALTER TABLE tasks ADD COLUMN timeout_seconds INT NOT NULL DEFAULT 0;
+
+ALTER TABLE tasks MODIFY COLUMN status ENUM('pending', 'running', 'failed', 'completed', 'interrupted', 'timed_out') DEFAULT 'pending';
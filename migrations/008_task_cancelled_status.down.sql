@@ -0,0 +1,3 @@
+UPDATE tasks SET status = 'failed' WHERE status = 'cancelled';
+
+ALTER TABLE tasks MODIFY COLUMN status ENUM('pending', 'running', 'failed', 'completed', 'interrupted', 'timed_out') DEFAULT 'pending';
@@ -0,0 +1 @@
+ALTER TABLE task_runs DROP COLUMN triggered_by;
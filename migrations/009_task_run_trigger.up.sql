@@ -0,0 +1 @@
+ALTER TABLE task_runs ADD COLUMN triggered_by VARCHAR(32) NOT NULL DEFAULT 'schedule';
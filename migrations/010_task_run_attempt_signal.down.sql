@@ -0,0 +1 @@
+ALTER TABLE task_run_attempts DROP COLUMN signal_name;
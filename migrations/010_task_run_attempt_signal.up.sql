@@ -0,0 +1 @@
+ALTER TABLE task_run_attempts ADD COLUMN signal_name VARCHAR(32) NOT NULL DEFAULT '' AFTER exit_code;
@@ -0,0 +1 @@
+ALTER TABLE tasks ADD COLUMN redact_patterns JSON NULL;
@@ -0,0 +1,4 @@
+ALTER TABLE tasks DROP COLUMN run_as_group;
+ALTER TABLE tasks DROP COLUMN run_as_user;
+ALTER TABLE tasks DROP COLUMN working_dir;
+ALTER TABLE tasks DROP COLUMN env;
@@ -0,0 +1,4 @@
+ALTER TABLE tasks ADD COLUMN env JSON NULL;
+ALTER TABLE tasks ADD COLUMN working_dir VARCHAR(1024) NOT NULL DEFAULT '';
+ALTER TABLE tasks ADD COLUMN run_as_user VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE tasks ADD COLUMN run_as_group VARCHAR(255) NOT NULL DEFAULT '';
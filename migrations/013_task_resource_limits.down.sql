@@ -0,0 +1,7 @@
+ALTER TABLE task_run_attempts DROP COLUMN oom_kills;
+ALTER TABLE task_runs DROP COLUMN oom_kills;
+
+ALTER TABLE tasks DROP COLUMN io_weight;
+ALTER TABLE tasks DROP COLUMN pids_max;
+ALTER TABLE tasks DROP COLUMN memory_max_bytes;
+ALTER TABLE tasks DROP COLUMN cpu_limit;
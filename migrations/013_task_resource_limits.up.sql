@@ -0,0 +1,7 @@
+ALTER TABLE tasks ADD COLUMN cpu_limit DOUBLE NOT NULL DEFAULT 0;
+ALTER TABLE tasks ADD COLUMN memory_max_bytes BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE tasks ADD COLUMN pids_max INT NOT NULL DEFAULT 0;
+ALTER TABLE tasks ADD COLUMN io_weight INT NOT NULL DEFAULT 0;
+
+ALTER TABLE task_runs ADD COLUMN oom_kills INT NOT NULL DEFAULT 0;
+ALTER TABLE task_run_attempts ADD COLUMN oom_kills INT NOT NULL DEFAULT 0 AFTER signal_name;
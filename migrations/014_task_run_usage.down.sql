@@ -0,0 +1,7 @@
+ALTER TABLE task_runs DROP COLUMN involuntary_ctx_switches;
+ALTER TABLE task_runs DROP COLUMN voluntary_ctx_switches;
+ALTER TABLE task_runs DROP COLUMN write_bytes;
+ALTER TABLE task_runs DROP COLUMN read_bytes;
+ALTER TABLE task_runs DROP COLUMN peak_rss_bytes;
+ALTER TABLE task_runs DROP COLUMN cpu_system_seconds;
+ALTER TABLE task_runs DROP COLUMN cpu_user_seconds;
@@ -0,0 +1,7 @@
+ALTER TABLE task_runs ADD COLUMN cpu_user_seconds DOUBLE NOT NULL DEFAULT 0;
+ALTER TABLE task_runs ADD COLUMN cpu_system_seconds DOUBLE NOT NULL DEFAULT 0;
+ALTER TABLE task_runs ADD COLUMN peak_rss_bytes BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE task_runs ADD COLUMN read_bytes BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE task_runs ADD COLUMN write_bytes BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE task_runs ADD COLUMN voluntary_ctx_switches BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE task_runs ADD COLUMN involuntary_ctx_switches BIGINT NOT NULL DEFAULT 0;
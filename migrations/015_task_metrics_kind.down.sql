@@ -0,0 +1,6 @@
+ALTER TABLE task_metrics DROP INDEX idx_task_metrics_run;
+ALTER TABLE task_metrics DROP COLUMN rss_bytes;
+ALTER TABLE task_metrics DROP COLUMN attempt;
+ALTER TABLE task_metrics DROP COLUMN run_id;
+ALTER TABLE task_metrics DROP COLUMN phase;
+ALTER TABLE task_metrics DROP COLUMN kind;
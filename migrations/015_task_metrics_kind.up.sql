@@ -0,0 +1,9 @@
+ALTER TABLE task_metrics ADD COLUMN kind ENUM('system', 'process') NOT NULL DEFAULT 'system' AFTER task_id;
+ALTER TABLE task_metrics ADD COLUMN phase VARCHAR(16) NOT NULL DEFAULT '' AFTER kind;
+ALTER TABLE task_metrics ADD COLUMN run_id BIGINT NULL DEFAULT NULL AFTER phase;
+ALTER TABLE task_metrics ADD COLUMN attempt INT NULL DEFAULT NULL AFTER run_id;
+ALTER TABLE task_metrics ADD COLUMN rss_bytes BIGINT NOT NULL DEFAULT 0 AFTER gpu_usage;
+ALTER TABLE task_metrics ADD INDEX idx_task_metrics_run (run_id, kind, recorded_at);
+
+-- Process samples were stored without a load average, which a system snapshot practically always has
+UPDATE task_metrics SET kind = 'process' WHERE load_average = 0 AND gpu_usage = 0;
@@ -0,0 +1 @@
+ALTER TABLE tasks DROP COLUMN sample_interval_seconds;
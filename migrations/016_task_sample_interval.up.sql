@@ -0,0 +1 @@
+ALTER TABLE tasks ADD COLUMN sample_interval_seconds INT NOT NULL DEFAULT 0 AFTER timeout_seconds;
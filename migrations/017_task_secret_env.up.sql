@@ -0,0 +1 @@
+ALTER TABLE tasks ADD COLUMN secret_env JSON NULL AFTER env;
@@ -0,0 +1 @@
+ALTER TABLE tasks DROP COLUMN lease_token;
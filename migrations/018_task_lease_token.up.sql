@@ -0,0 +1 @@
+ALTER TABLE tasks ADD COLUMN lease_token VARCHAR(32) NULL DEFAULT NULL AFTER lease_owner;
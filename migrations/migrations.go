@@ -0,0 +1,11 @@
+// Package migrations embeds the versioned SQL schema migrations.
+//
+// Each migration is a pair of files named <version>_<name>.up.sql and
+// <version>_<name>.down.sql. Statements within a file are separated by
+// semicolons; those in quotes and comments do not separate statements.
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS
@@ -0,0 +1,327 @@
+// Package config loads gronicle's server configuration from YAML files and
+// GRONICLE_* environment variables.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the complete server configuration.
+type Config struct {
+	Server    ServerConfig    `yaml:"server"`
+	MySQL     MySQLConfig     `yaml:"mysql"`
+	Logs      LogsConfig      `yaml:"logs"`
+	S3        S3Config        `yaml:"s3"`
+	Scheduler SchedulerConfig `yaml:"scheduler"`
+	Redaction RedactionConfig `yaml:"redaction"`
+	Metrics   MetricsConfig   `yaml:"metrics"`
+}
+
+// ServerConfig configures the HTTP API server.
+type ServerConfig struct {
+	Port int `yaml:"port"`
+}
+
+// MySQLConfig configures the MySQL connection.
+type MySQLConfig struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	Database string `yaml:"database"`
+}
+
+// Address returns the host:port the MySQL server listens on.
+func (c MySQLConfig) Address() string {
+	return net.JoinHostPort(c.Host, strconv.Itoa(c.Port))
+}
+
+// RedactionConfig configures the secrets masked in the output of every task. Tasks can add their
+// own patterns, and the values of environment variables injected into a run are always masked.
+type RedactionConfig struct {
+	Patterns        []string `yaml:"patterns"`         // Regular expressions whose matches are replaced by [REDACTED]
+	DisableDefaults bool     `yaml:"disable_defaults"` // Skip DefaultRedactPatterns
+}
+
+// AllPatterns returns the configured patterns, preceded by DefaultRedactPatterns unless they are disabled.
+func (r RedactionConfig) AllPatterns() []string {
+	if r.DisableDefaults {
+		return r.Patterns
+	}
+	return append(append([]string{}, DefaultRedactPatterns...), r.Patterns...)
+}
+
+// DefaultRedactPatterns catch common credentials in task output.
+var DefaultRedactPatterns = []string{
+	`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`,                                 // AWS access key IDs
+	`(?i)aws_secret_access_key\s*[=:]\s*["']?[A-Za-z0-9/+=]{40}`,    // AWS secret access keys
+	`(?i)\bbearer\s+[A-Za-z0-9\-._~+/]+=*`,                          // Bearer tokens
+	`(?i)\b(?:password|passwd|secret|token)\s*[=:]\s*["']?[^\s"']+`, // Assigned passwords, secrets and tokens
+}
+
+// Log sink types.
+const (
+	LogSinkS3    = "s3"
+	LogSinkLocal = "local"
+)
+
+// LogsConfig selects where task logs are stored.
+type LogsConfig struct {
+	Sink     string `yaml:"sink"`      // "s3" or "local"
+	LocalDir string `yaml:"local_dir"` // Directory used by the local sink
+	// ReplayInterval is how often logs that fell back to local_logs during an S3 outage are retried.
+	ReplayInterval time.Duration `yaml:"replay_interval"`
+}
+
+// S3Config configures the S3 bucket task logs are uploaded to when logs.sink is "s3".
+type S3Config struct {
+	Bucket       string `yaml:"bucket"`
+	Region       string `yaml:"region"`
+	Endpoint     string `yaml:"endpoint"`       // Custom endpoint for S3-compatible stores such as MinIO
+	UsePathStyle bool   `yaml:"use_path_style"` // Path-style addressing, required by most S3-compatible stores
+	Compress     bool   `yaml:"compress"`       // Gzip logs on upload
+}
+
+// SchedulerConfig configures polling and the worker pool.
+type SchedulerConfig struct {
+	Workers      int           `yaml:"workers"`
+	RetryLimit   int           `yaml:"retry_limit"`
+	PollInterval time.Duration `yaml:"poll_interval"`
+	DrainTimeout time.Duration `yaml:"drain_timeout"` // How long in-flight runs may finish during shutdown
+	// CgroupParent is the cgroup v2 directory under which tasks with resource limits get their own cgroup
+	CgroupParent string `yaml:"cgroup_parent"`
+}
+
+// MetricsConfig configures how task metrics are written to MySQL.
+type MetricsConfig struct {
+	BatchSize      int           `yaml:"batch_size"`      // Rows stored per INSERT
+	FlushInterval  time.Duration `yaml:"flush_interval"`  // Longest a row waits before it is stored
+	QueueSize      int           `yaml:"queue_size"`      // Rows held while MySQL is slow before new ones are dropped
+	SampleInterval time.Duration `yaml:"sample_interval"` // How often running tasks' processes are sampled
+	MaxSamples     int           `yaml:"max_samples"`     // Samples kept per attempt, the most recent ones
+}
+
+// Default returns the configuration used for any value not set by a file or the environment.
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{Port: 9999},
+		MySQL:  MySQLConfig{Host: "localhost", Port: 3306},
+		Logs:   LogsConfig{Sink: LogSinkS3, LocalDir: "logs", ReplayInterval: time.Minute},
+		Scheduler: SchedulerConfig{
+			Workers:      5,
+			RetryLimit:   3,
+			PollInterval: 10 * time.Second,
+			DrainTimeout: 30 * time.Second,
+			CgroupParent: "/sys/fs/cgroup/gronicle",
+		},
+		Metrics: MetricsConfig{
+			BatchSize:      200,
+			FlushInterval:  2 * time.Second,
+			QueueSize:      10000,
+			SampleInterval: time.Second,
+			MaxSamples:     3600,
+		},
+	}
+}
+
+// Load builds the configuration from the defaults, then each YAML file in order, then the
+// environment. Later files override earlier ones key by key, so settings can be split
+// across files. The result is validated before it is returned.
+func Load(paths ...string) (*Config, error) {
+	cfg := Default()
+
+	for _, path := range paths {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading config %s: %w", path, err)
+		}
+		if err := yaml.Unmarshal(content, cfg); err != nil {
+			return nil, fmt.Errorf("parsing config %s: %w", path, err)
+		}
+	}
+
+	if err := cfg.applyEnv(os.LookupEnv); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// applyEnv overrides values with the GRONICLE_* environment variables that are set.
+// SERVER_PORT is still honoured for existing deployments, but GRONICLE_SERVER_PORT wins.
+func (c *Config) applyEnv(lookup func(string) (string, bool)) error {
+	overrides := []struct {
+		name  string
+		apply func(string) error
+	}{
+		{"SERVER_PORT", intSetter(&c.Server.Port)},
+		{"GRONICLE_SERVER_PORT", intSetter(&c.Server.Port)},
+		{"GRONICLE_MYSQL_HOST", stringSetter(&c.MySQL.Host)},
+		{"GRONICLE_MYSQL_PORT", intSetter(&c.MySQL.Port)},
+		{"GRONICLE_MYSQL_USER", stringSetter(&c.MySQL.User)},
+		{"GRONICLE_MYSQL_PASSWORD", stringSetter(&c.MySQL.Password)},
+		{"GRONICLE_MYSQL_DATABASE", stringSetter(&c.MySQL.Database)},
+		{"GRONICLE_LOGS_SINK", stringSetter(&c.Logs.Sink)},
+		{"GRONICLE_LOGS_LOCAL_DIR", stringSetter(&c.Logs.LocalDir)},
+		{"GRONICLE_LOGS_REPLAY_INTERVAL", durationSetter(&c.Logs.ReplayInterval)},
+		{"GRONICLE_S3_BUCKET", stringSetter(&c.S3.Bucket)},
+		{"GRONICLE_S3_REGION", stringSetter(&c.S3.Region)},
+		{"GRONICLE_S3_ENDPOINT", stringSetter(&c.S3.Endpoint)},
+		{"GRONICLE_S3_USE_PATH_STYLE", boolSetter(&c.S3.UsePathStyle)},
+		{"GRONICLE_S3_COMPRESS", boolSetter(&c.S3.Compress)},
+		{"GRONICLE_SCHEDULER_WORKERS", intSetter(&c.Scheduler.Workers)},
+		{"GRONICLE_SCHEDULER_RETRY_LIMIT", intSetter(&c.Scheduler.RetryLimit)},
+		{"GRONICLE_SCHEDULER_POLL_INTERVAL", durationSetter(&c.Scheduler.PollInterval)},
+		{"GRONICLE_SCHEDULER_DRAIN_TIMEOUT", durationSetter(&c.Scheduler.DrainTimeout)},
+		{"GRONICLE_SCHEDULER_CGROUP_PARENT", stringSetter(&c.Scheduler.CgroupParent)},
+		{"GRONICLE_METRICS_BATCH_SIZE", intSetter(&c.Metrics.BatchSize)},
+		{"GRONICLE_METRICS_FLUSH_INTERVAL", durationSetter(&c.Metrics.FlushInterval)},
+		{"GRONICLE_METRICS_QUEUE_SIZE", intSetter(&c.Metrics.QueueSize)},
+		{"GRONICLE_METRICS_SAMPLE_INTERVAL", durationSetter(&c.Metrics.SampleInterval)},
+		{"GRONICLE_METRICS_MAX_SAMPLES", intSetter(&c.Metrics.MaxSamples)},
+	}
+
+	for _, override := range overrides {
+		value, ok := lookup(override.name)
+		if !ok {
+			continue
+		}
+		if err := override.apply(value); err != nil {
+			return fmt.Errorf("invalid %s: %w", override.name, err)
+		}
+	}
+	return nil
+}
+
+// Validate reports every invalid value in the configuration.
+func (c *Config) Validate() error {
+	var errs []error
+
+	if c.Server.Port < 1 || c.Server.Port > 65535 {
+		errs = append(errs, fmt.Errorf("server.port must be between 1 and 65535, got %d", c.Server.Port))
+	}
+	if c.MySQL.Host == "" {
+		errs = append(errs, errors.New("mysql.host is required"))
+	}
+	if c.MySQL.Port < 1 || c.MySQL.Port > 65535 {
+		errs = append(errs, fmt.Errorf("mysql.port must be between 1 and 65535, got %d", c.MySQL.Port))
+	}
+	if c.MySQL.User == "" {
+		errs = append(errs, errors.New("mysql.user is required"))
+	}
+	if c.MySQL.Database == "" {
+		errs = append(errs, errors.New("mysql.database is required"))
+	}
+	switch c.Logs.Sink {
+	case LogSinkS3:
+		if c.S3.Bucket == "" {
+			errs = append(errs, errors.New("s3.bucket is required"))
+		}
+		if c.S3.Region == "" {
+			errs = append(errs, errors.New("s3.region is required"))
+		}
+		if c.Logs.ReplayInterval <= 0 {
+			errs = append(errs, fmt.Errorf("logs.replay_interval must be positive, got %s", c.Logs.ReplayInterval))
+		}
+	case LogSinkLocal:
+		if c.Logs.LocalDir == "" {
+			errs = append(errs, errors.New("logs.local_dir is required"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("logs.sink must be %q or %q, got %q", LogSinkS3, LogSinkLocal, c.Logs.Sink))
+	}
+	if c.Scheduler.Workers < 1 {
+		errs = append(errs, fmt.Errorf("scheduler.workers must be at least 1, got %d", c.Scheduler.Workers))
+	}
+	if c.Scheduler.RetryLimit < 1 {
+		errs = append(errs, fmt.Errorf("scheduler.retry_limit must be at least 1, got %d", c.Scheduler.RetryLimit))
+	}
+	if c.Scheduler.PollInterval <= 0 {
+		errs = append(errs, fmt.Errorf("scheduler.poll_interval must be positive, got %s", c.Scheduler.PollInterval))
+	}
+
+	if c.Scheduler.DrainTimeout < 0 {
+		errs = append(errs, fmt.Errorf("scheduler.drain_timeout must not be negative, got %s", c.Scheduler.DrainTimeout))
+	}
+
+	// Each row takes 12 placeholders and MySQL allows 65535 per statement
+	if c.Metrics.BatchSize < 1 || c.Metrics.BatchSize > 5000 {
+		errs = append(errs, fmt.Errorf("metrics.batch_size must be between 1 and 5000, got %d", c.Metrics.BatchSize))
+	}
+	if c.Metrics.FlushInterval <= 0 {
+		errs = append(errs, fmt.Errorf("metrics.flush_interval must be positive, got %s", c.Metrics.FlushInterval))
+	}
+	if c.Metrics.QueueSize < 1 {
+		errs = append(errs, fmt.Errorf("metrics.queue_size must be at least 1, got %d", c.Metrics.QueueSize))
+	}
+	if c.Metrics.SampleInterval < 100*time.Millisecond {
+		errs = append(errs, fmt.Errorf("metrics.sample_interval must be at least 100ms, got %s", c.Metrics.SampleInterval))
+	}
+	if c.Metrics.MaxSamples < 1 {
+		errs = append(errs, fmt.Errorf("metrics.max_samples must be at least 1, got %d", c.Metrics.MaxSamples))
+	}
+	// An attempt's samples are queued all at once when it ends, so they must at least fit in the queue;
+	// attempts ending together can still fill it, and their excess samples are dropped and counted
+	if c.Metrics.MaxSamples > c.Metrics.QueueSize {
+		errs = append(errs, fmt.Errorf("metrics.max_samples (%d) must not exceed metrics.queue_size (%d)", c.Metrics.MaxSamples, c.Metrics.QueueSize))
+	}
+
+	for _, pattern := range c.Redaction.Patterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			errs = append(errs, fmt.Errorf("redaction.patterns: invalid pattern %q: %w", pattern, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func stringSetter(dst *string) func(string) error {
+	return func(value string) error {
+		*dst = value
+		return nil
+	}
+}
+
+func intSetter(dst *int) func(string) error {
+	return func(value string) error {
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		*dst = parsed
+		return nil
+	}
+}
+
+func boolSetter(dst *bool) func(string) error {
+	return func(value string) error {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		*dst = parsed
+		return nil
+	}
+}
+
+func durationSetter(dst *time.Duration) func(string) error {
+	return func(value string) error {
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		*dst = parsed
+		return nil
+	}
+}
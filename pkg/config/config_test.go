@@ -0,0 +1,165 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// validConfig returns the defaults with the values Default leaves empty filled in.
+func validConfig() *Config {
+	cfg := Default()
+	cfg.MySQL.User = "gronicle"
+	cfg.MySQL.Database = "gronicle"
+	cfg.S3.Bucket = "gronicle-logs"
+	cfg.S3.Region = "eu-west-1"
+	return cfg
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(*Config)
+		wantErr string // Substring of the error, empty for a valid configuration
+	}{
+		{name: "valid", modify: func(*Config) {}},
+		{name: "server port too high", modify: func(c *Config) { c.Server.Port = 65536 }, wantErr: "server.port"},
+		{name: "mysql host missing", modify: func(c *Config) { c.MySQL.Host = "" }, wantErr: "mysql.host is required"},
+		{name: "mysql user missing", modify: func(c *Config) { c.MySQL.User = "" }, wantErr: "mysql.user is required"},
+		{name: "s3 bucket missing", modify: func(c *Config) { c.S3.Bucket = "" }, wantErr: "s3.bucket is required"},
+		{name: "s3 replay interval zero", modify: func(c *Config) { c.Logs.ReplayInterval = 0 }, wantErr: "logs.replay_interval"},
+		{
+			name:   "local sink without s3",
+			modify: func(c *Config) { c.Logs.Sink = LogSinkLocal; c.S3 = S3Config{}; c.Logs.ReplayInterval = 0 },
+		},
+		{name: "local sink without dir", modify: func(c *Config) { c.Logs.Sink = LogSinkLocal; c.Logs.LocalDir = "" }, wantErr: "logs.local_dir"},
+		{name: "unknown sink", modify: func(c *Config) { c.Logs.Sink = "ftp" }, wantErr: "logs.sink"},
+		{name: "no workers", modify: func(c *Config) { c.Scheduler.Workers = 0 }, wantErr: "scheduler.workers"},
+		{name: "negative drain timeout", modify: func(c *Config) { c.Scheduler.DrainTimeout = -time.Second }, wantErr: "scheduler.drain_timeout"},
+		{name: "zero drain timeout", modify: func(c *Config) { c.Scheduler.DrainTimeout = 0 }},
+		{name: "batch size zero", modify: func(c *Config) { c.Metrics.BatchSize = 0 }, wantErr: "metrics.batch_size"},
+		{name: "batch size at the placeholder limit", modify: func(c *Config) { c.Metrics.BatchSize = 5000 }},
+		{name: "batch size over the placeholder limit", modify: func(c *Config) { c.Metrics.BatchSize = 5001 }, wantErr: "metrics.batch_size"},
+		{name: "queue size zero", modify: func(c *Config) { c.Metrics.QueueSize = 0 }, wantErr: "metrics.queue_size"},
+		{name: "sample interval too short", modify: func(c *Config) { c.Metrics.SampleInterval = 10 * time.Millisecond }, wantErr: "metrics.sample_interval"},
+		{name: "max samples zero", modify: func(c *Config) { c.Metrics.MaxSamples = 0 }, wantErr: "metrics.max_samples must be at least 1"},
+		{name: "max samples equal to queue size", modify: func(c *Config) { c.Metrics.MaxSamples = c.Metrics.QueueSize }},
+		{
+			name:    "max samples over queue size",
+			modify:  func(c *Config) { c.Metrics.QueueSize = 100; c.Metrics.MaxSamples = 101 },
+			wantErr: "metrics.max_samples (101) must not exceed metrics.queue_size (100)",
+		},
+		{name: "invalid redaction pattern", modify: func(c *Config) { c.Redaction.Patterns = []string{"("} }, wantErr: "redaction.patterns"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := validConfig()
+			tt.modify(cfg)
+
+			err := cfg.Validate()
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("Validate() = %v, want no error", err)
+			case tt.wantErr != "" && err == nil:
+				t.Errorf("Validate() = nil, want an error containing %q", tt.wantErr)
+			case tt.wantErr != "" && !strings.Contains(err.Error(), tt.wantErr):
+				t.Errorf("Validate() = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateReportsEveryError(t *testing.T) {
+	cfg := validConfig()
+	cfg.Scheduler.Workers = 0
+	cfg.Metrics.BatchSize = 0
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("Validate() = nil, want errors")
+	}
+	for _, want := range []string{"scheduler.workers", "metrics.batch_size"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Validate() = %v, want it to mention %s", err, want)
+		}
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		check   func(*Config) bool
+		wantErr string
+	}{
+		{
+			name:  "nothing set",
+			env:   map[string]string{},
+			check: func(c *Config) bool { return c.Server.Port == 9999 && c.Metrics.QueueSize == 10000 },
+		},
+		{
+			name:  "legacy server port",
+			env:   map[string]string{"SERVER_PORT": "8080"},
+			check: func(c *Config) bool { return c.Server.Port == 8080 },
+		},
+		{
+			name:  "prefixed server port wins",
+			env:   map[string]string{"SERVER_PORT": "8080", "GRONICLE_SERVER_PORT": "8081"},
+			check: func(c *Config) bool { return c.Server.Port == 8081 },
+		},
+		{
+			name:  "string",
+			env:   map[string]string{"GRONICLE_MYSQL_HOST": "db.internal"},
+			check: func(c *Config) bool { return c.MySQL.Host == "db.internal" },
+		},
+		{
+			name:  "bool",
+			env:   map[string]string{"GRONICLE_S3_COMPRESS": "true"},
+			check: func(c *Config) bool { return c.S3.Compress },
+		},
+		{
+			name:  "duration",
+			env:   map[string]string{"GRONICLE_SCHEDULER_DRAIN_TIMEOUT": "90s"},
+			check: func(c *Config) bool { return c.Scheduler.DrainTimeout == 90*time.Second },
+		},
+		{
+			name: "metrics",
+			env: map[string]string{
+				"GRONICLE_METRICS_QUEUE_SIZE":      "500",
+				"GRONICLE_METRICS_MAX_SAMPLES":     "300",
+				"GRONICLE_METRICS_SAMPLE_INTERVAL": "250ms",
+			},
+			check: func(c *Config) bool {
+				return c.Metrics.QueueSize == 500 && c.Metrics.MaxSamples == 300 && c.Metrics.SampleInterval == 250*time.Millisecond
+			},
+		},
+		{name: "invalid int", env: map[string]string{"GRONICLE_METRICS_QUEUE_SIZE": "lots"}, wantErr: "invalid GRONICLE_METRICS_QUEUE_SIZE"},
+		{name: "invalid bool", env: map[string]string{"GRONICLE_S3_USE_PATH_STYLE": "sometimes"}, wantErr: "invalid GRONICLE_S3_USE_PATH_STYLE"},
+		{name: "invalid duration", env: map[string]string{"GRONICLE_SCHEDULER_POLL_INTERVAL": "10"}, wantErr: "invalid GRONICLE_SCHEDULER_POLL_INTERVAL"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lookup := func(name string) (string, bool) {
+				value, ok := tt.env[name]
+				return value, ok
+			}
+
+			cfg := Default()
+			err := cfg.applyEnv(lookup)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("applyEnv() = %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("applyEnv() = %v", err)
+			}
+			if !tt.check(cfg) {
+				t.Errorf("applyEnv(%v) gave %+v", tt.env, cfg)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package monitor
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/shirou/gopsutil/v3/process"
+)
+
+// procChildrenSupported reports whether the kernel lists each thread's children in
+// /proc/<pid>/task/<tid>/children, which needs CONFIG_PROC_CHILDREN.
+var procChildrenSupported = sync.OnceValue(func() bool {
+	_, err := os.Stat("/proc/thread-self/children")
+	return err == nil
+})
+
+// descendants returns the processes below pid in the process tree. It follows the children /proc
+// lists for each process down from pid, so that sampling a task only reads its own processes
+// rather than every process on the host.
+func descendants(pid int32) []*process.Process {
+	if !procChildrenSupported() {
+		return scanDescendants(pid)
+	}
+
+	var found []*process.Process
+	queue := procChildren(pid)
+	for len(queue) > 0 {
+		child := queue[0]
+		queue = append(queue[1:], procChildren(child)...)
+		// A process may exit between being listed and being read
+		if proc, err := process.NewProcess(child); err == nil {
+			found = append(found, proc)
+		}
+	}
+	return found
+}
+
+// procChildren returns the PIDs of the children of every thread of pid, or none if it has exited.
+func procChildren(pid int32) []int32 {
+	taskDir := filepath.Join("/proc", strconv.Itoa(int(pid)), "task")
+	threads, err := os.ReadDir(taskDir)
+	if err != nil {
+		return nil
+	}
+
+	var children []int32
+	for _, thread := range threads {
+		content, err := os.ReadFile(filepath.Join(taskDir, thread.Name(), "children"))
+		if err != nil {
+			continue
+		}
+		for _, field := range strings.Fields(string(content)) {
+			if child, err := strconv.ParseInt(field, 10, 32); err == nil {
+				children = append(children, int32(child))
+			}
+		}
+	}
+	return children
+}
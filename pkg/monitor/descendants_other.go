@@ -0,0 +1,10 @@
+//go:build !linux
+
+package monitor
+
+import "github.com/shirou/gopsutil/v3/process"
+
+// descendants returns the processes below pid in the process tree.
+func descendants(pid int32) []*process.Process {
+	return scanDescendants(pid)
+}
@@ -2,7 +2,6 @@ package monitor
 
 import (
 	"github.com/shirou/gopsutil/v3/process"
-	"log"
 	"time"
 
 	"github.com/shirou/gopsutil/v3/cpu"
@@ -38,30 +37,95 @@ func CollectMetrics() TaskMetrics {
 	}
 }
 
+// ProcessMetrics is a sample of a task's process tree: the command's process and every descendant.
 type ProcessMetrics struct {
-	CPUUsage   float64 `json:"cpu_usage"`
-	RAMUsage   float64 `json:"ram_usage"`
-	DiskUsage  float64 `json:"disk_usage"`
+	CPUUsage   float64 `json:"cpu_usage"`  // Sum of the processes' CPU percentages
+	RAMUsage   float64 `json:"ram_usage"`  // Percentage of system memory the processes use together
+	DiskUsage  float64 `json:"disk_usage"` // Bytes the processes have read and written so far
+	RSSBytes   int64   `json:"rss_bytes"`  // Resident memory of the processes together
 	RecordedAt time.Time
 }
 
-// CollectProcessMetrics gathers metrics for the specific task's process (by PID).
-func CollectProcessMetrics(pid int32) ProcessMetrics {
-	proc, err := process.NewProcess(pid)
+// CollectProcessMetrics gathers metrics for the task's process (by PID) and its descendants. It
+// fails if the process no longer exists.
+func CollectProcessMetrics(pid int32) (ProcessMetrics, error) {
+	root, err := process.NewProcess(pid)
 	if err != nil {
-		log.Printf("Failed to monitor process (PID: %d): %v", pid, err)
-		return ProcessMetrics{RecordedAt: time.Now()}
+		return ProcessMetrics{}, err
 	}
+	return collectMetrics(append([]*process.Process{root}, descendants(pid)...)), nil
+}
+
+// CollectPIDMetrics gathers metrics for the listed processes together, such as those of a cgroup.
+// Processes that no longer exist are skipped, and it fails if none of them does.
+func CollectPIDMetrics(pids []int32) (ProcessMetrics, error) {
+	var procs []*process.Process
+	for _, pid := range pids {
+		if proc, err := process.NewProcess(pid); err == nil {
+			procs = append(procs, proc)
+		}
+	}
+	if len(procs) == 0 {
+		return ProcessMetrics{}, process.ErrorProcessNotRunning
+	}
+	return collectMetrics(procs), nil
+}
+
+// collectMetrics sums the metrics of procs.
+func collectMetrics(procs []*process.Process) ProcessMetrics {
+	metrics := ProcessMetrics{RecordedAt: time.Now()}
+	for _, proc := range procs {
+		// A process may exit between being listed and being read, so errors only skip a value
+		if cpuPercent, err := proc.CPUPercent(); err == nil {
+			metrics.CPUUsage += cpuPercent
+		}
+		if memPercent, err := proc.MemoryPercent(); err == nil {
+			metrics.RAMUsage += float64(memPercent)
+		}
+		if memInfo, err := proc.MemoryInfo(); err == nil {
+			metrics.RSSBytes += int64(memInfo.RSS)
+		}
+		if io, err := proc.IOCounters(); err == nil {
+			metrics.DiskUsage += float64(io.ReadBytes + io.WriteBytes)
+		}
+	}
+	return metrics
+}
 
-	// Collect CPU, memory, and disk usage (disk stats simulated)
-	cpuPercent, _ := proc.CPUPercent()
-	memPercent, _ := proc.MemoryPercent()
-	diskUsage := 0.0 // Placeholder - per-process disk usage is typically not directly available
+// scanDescendants returns the processes below pid in the process tree by listing every process
+// on the host, for systems that cannot list a process's children directly.
+func scanDescendants(pid int32) []*process.Process {
+	procs, err := process.Processes()
+	if err != nil {
+		return nil
+	}
 
-	return ProcessMetrics{
-		CPUUsage:   cpuPercent,
-		RAMUsage:   float64(memPercent),
-		DiskUsage:  diskUsage,
-		RecordedAt: time.Now(),
+	children := make(map[int32][]*process.Process)
+	for _, proc := range procs {
+		if ppid, err := proc.Ppid(); err == nil {
+			children[ppid] = append(children[ppid], proc)
+		}
 	}
+
+	var found []*process.Process
+	queue := []int32{pid}
+	for len(queue) > 0 {
+		for _, child := range children[queue[0]] {
+			found = append(found, child)
+			queue = append(queue, child.Pid)
+		}
+		queue = queue[1:]
+	}
+	return found
+}
+
+// ResourceUsage is what a run's processes consumed in total.
+type ResourceUsage struct {
+	CPUUserSeconds         float64 `json:"cpu_user_seconds"`
+	CPUSystemSeconds       float64 `json:"cpu_system_seconds"`
+	PeakRSSBytes           int64   `json:"peak_rss_bytes"`
+	ReadBytes              int64   `json:"read_bytes"`  // Read from storage, not from the page cache
+	WriteBytes             int64   `json:"write_bytes"` // Written to storage
+	VoluntaryCtxSwitches   int64   `json:"voluntary_ctx_switches"`
+	InvoluntaryCtxSwitches int64   `json:"involuntary_ctx_switches"`
 }
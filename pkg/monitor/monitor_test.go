@@ -0,0 +1,72 @@
+package monitor
+
+import (
+	"os"
+	"os/exec"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/process"
+)
+
+func pids(procs []*process.Process) []int32 {
+	var found []int32
+	for _, proc := range procs {
+		found = append(found, proc.Pid)
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i] < found[j] })
+	return found
+}
+
+func TestDescendants(t *testing.T) {
+	// A shell with a child that has a child of its own
+	cmd := exec.Command("/bin/sh", "-c", `sh -c "sleep 30 & wait" & sleep 30 & wait`)
+	if err := cmd.Start(); err != nil {
+		t.Skipf("cannot start /bin/sh: %v", err)
+	}
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+
+	pid := int32(cmd.Process.Pid)
+	var found []int32
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		if found = pids(descendants(pid)); len(found) == 3 {
+			break
+		}
+	}
+	if len(found) != 3 {
+		t.Fatalf("descendants(%d) = %v, want the inner shell and two sleeps", pid, found)
+	}
+	if scanned := pids(scanDescendants(pid)); len(scanned) != len(found) {
+		t.Errorf("descendants(%d) = %v, but scanning every process found %v", pid, found, scanned)
+	}
+
+	metrics, err := CollectProcessMetrics(pid)
+	if err != nil {
+		t.Fatalf("CollectProcessMetrics: %v", err)
+	}
+	if metrics.RSSBytes <= 0 {
+		t.Errorf("CollectProcessMetrics RSSBytes = %d, want the tree's resident memory", metrics.RSSBytes)
+	}
+}
+
+func TestCollectPIDMetrics(t *testing.T) {
+	self := int32(os.Getpid())
+	metrics, err := CollectPIDMetrics([]int32{self, 1 << 30})
+	if err != nil {
+		t.Fatalf("CollectPIDMetrics skipping a missing process: %v", err)
+	}
+	if metrics.RSSBytes <= 0 {
+		t.Errorf("CollectPIDMetrics RSSBytes = %d, want this process's resident memory", metrics.RSSBytes)
+	}
+
+	if _, err := CollectPIDMetrics([]int32{1 << 30}); err == nil {
+		t.Error("CollectPIDMetrics succeeded without any running process")
+	}
+	if _, err := CollectPIDMetrics(nil); err == nil {
+		t.Error("CollectPIDMetrics succeeded without any process")
+	}
+}
@@ -0,0 +1,203 @@
+package scheduler
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/shammishailaj/gronicle/pkg/monitor"
+	"github.com/shammishailaj/gronicle/pkg/storage"
+)
+
+// cpuPeriod is the cpu.max period, in microseconds, that a task's CPU limit is a quota of.
+const cpuPeriod = 100000
+
+// taskCgroup is the cgroup v2 leaf an attempt's command runs in.
+type taskCgroup struct {
+	path string
+	dir  *os.File // Held open so the child can be started directly inside the cgroup
+}
+
+// newTaskCgroup creates the leaf cgroup name under parent with limits applied. The controllers
+// the limits need are enabled in parent first; parent must be a cgroup gronicle may manage that
+// has no processes of its own, as cgroup v2 only lets leaves hold processes.
+func newTaskCgroup(parent, name string, limits storage.ResourceLimits) (*taskCgroup, error) {
+	if parent == "" {
+		return nil, errors.New("task has resource limits but no cgroup parent is configured")
+	}
+	if err := os.MkdirAll(parent, 0o755); err != nil {
+		return nil, err
+	}
+
+	var controllers []string
+	settings := make(map[string]string)
+	if limits.CPU > 0 {
+		controllers = append(controllers, "cpu")
+		settings["cpu.max"] = fmt.Sprintf("%d %d", int64(limits.CPU*cpuPeriod), cpuPeriod)
+	}
+	if limits.MemoryMaxBytes > 0 {
+		controllers = append(controllers, "memory")
+		settings["memory.max"] = strconv.FormatInt(limits.MemoryMaxBytes, 10)
+	}
+	if limits.PidsMax > 0 {
+		controllers = append(controllers, "pids")
+		settings["pids.max"] = strconv.Itoa(limits.PidsMax)
+	}
+	if limits.IOWeight > 0 {
+		controllers = append(controllers, "io")
+		settings["io.weight"] = fmt.Sprintf("default %d", limits.IOWeight)
+	}
+
+	for _, controller := range controllers {
+		// A controller is only available in parent if its own parent delegates it, which a parent
+		// gronicle created itself may not have yet
+		writeCgroupFile(filepath.Dir(parent), "cgroup.subtree_control", "+"+controller)
+		if err := writeCgroupFile(parent, "cgroup.subtree_control", "+"+controller); err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				err = errors.New("controller not available")
+			}
+			return nil, fmt.Errorf("enabling the %s controller in %s: %w", controller, parent, err)
+		}
+	}
+
+	path := filepath.Join(parent, name)
+	if err := os.Mkdir(path, 0o755); err != nil {
+		return nil, err
+	}
+	cgroup := &taskCgroup{path: path}
+
+	for file, value := range settings {
+		if err := writeCgroupFile(path, file, value); err != nil {
+			cgroup.remove()
+			return nil, fmt.Errorf("setting %s: %w", file, err)
+		}
+	}
+
+	dir, err := os.Open(path)
+	if err != nil {
+		cgroup.remove()
+		return nil, err
+	}
+	cgroup.dir = dir
+	return cgroup, nil
+}
+
+// apply makes cmd start inside the cgroup, so that no process of the task ever runs outside it.
+func (c *taskCgroup) apply(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(c.dir.Fd())
+}
+
+// oomKills returns how many processes in the cgroup the kernel OOM-killed.
+func (c *taskCgroup) oomKills() int {
+	// memory.events only exists with the memory controller, without which nothing is OOM-killed for the cgroup's limit
+	return int(readCgroupKeyed(filepath.Join(c.path, "memory.events"))["oom_kill"])
+}
+
+// usage returns what every process that ran in the cgroup consumed, including processes the
+// command left running in the background. Memory and IO are only accounted when their
+// controllers are enabled, that is when the task limits them; they are zero otherwise.
+func (c *taskCgroup) usage() monitor.ResourceUsage {
+	var usage monitor.ResourceUsage
+	// cpu.stat is always present, whether or not the cpu controller is enabled
+	cpuStat := readCgroupKeyed(filepath.Join(c.path, "cpu.stat"))
+	usage.CPUUserSeconds = float64(cpuStat["user_usec"]) / 1e6
+	usage.CPUSystemSeconds = float64(cpuStat["system_usec"]) / 1e6
+
+	// memory.peak needs Linux 5.19
+	if peak, err := os.ReadFile(filepath.Join(c.path, "memory.peak")); err == nil {
+		usage.PeakRSSBytes, _ = strconv.ParseInt(strings.TrimSpace(string(peak)), 10, 64)
+	}
+
+	// io.stat has a line per device: "8:0 rbytes=1024 wbytes=4096 rios=1 wios=1 ..."
+	if ioStat, err := os.ReadFile(filepath.Join(c.path, "io.stat")); err == nil {
+		for _, line := range strings.Split(string(ioStat), "\n") {
+			for _, field := range strings.Fields(line) {
+				key, value, _ := strings.Cut(field, "=")
+				n, _ := strconv.ParseInt(value, 10, 64)
+				switch key {
+				case "rbytes":
+					usage.ReadBytes += n
+				case "wbytes":
+					usage.WriteBytes += n
+				}
+			}
+		}
+	}
+	return usage
+}
+
+// processMetrics samples every process in the cgroup, including any the command left running in
+// the background, reading only the cgroup's own process list. It fails once the cgroup is empty.
+func (c *taskCgroup) processMetrics() (monitor.ProcessMetrics, error) {
+	procs, err := os.ReadFile(filepath.Join(c.path, "cgroup.procs"))
+	if err != nil {
+		return monitor.ProcessMetrics{}, err
+	}
+
+	var pids []int32
+	for _, field := range strings.Fields(string(procs)) {
+		if pid, err := strconv.ParseInt(field, 10, 32); err == nil {
+			pids = append(pids, int32(pid))
+		}
+	}
+	return monitor.CollectPIDMetrics(pids)
+}
+
+// remove kills whatever is left in the cgroup, such as background processes that outlived the
+// command, and deletes it.
+func (c *taskCgroup) remove() {
+	if c.dir != nil {
+		c.dir.Close()
+	}
+
+	// cgroup.kill needs Linux 5.14; on older kernels leftover processes keep the cgroup alive
+	if err := writeCgroupFile(c.path, "cgroup.kill", "1"); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Printf("Failed to kill the processes left in cgroup %s: %v", c.path, err)
+	}
+
+	// Killed processes take a moment to leave the cgroup
+	var err error
+	for i := 0; i < 50; i++ {
+		if err = os.Remove(c.path); err == nil || errors.Is(err, fs.ErrNotExist) {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	log.Printf("Failed to remove cgroup %s: %v", c.path, err)
+}
+
+// readCgroupKeyed reads a flat keyed cgroup file such as cpu.stat, made of "key value" lines.
+// A missing file reads as empty.
+func readCgroupKeyed(path string) map[string]int64 {
+	values := make(map[string]int64)
+	file, err := os.Open(path)
+	if err != nil {
+		return values
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		key, value, _ := strings.Cut(scanner.Text(), " ")
+		values[key], _ = strconv.ParseInt(value, 10, 64)
+	}
+	return values
+}
+
+// writeCgroupFile writes value to a cgroup interface file.
+func writeCgroupFile(dir, file, value string) error {
+	return os.WriteFile(filepath.Join(dir, file), []byte(value), 0o644)
+}
@@ -0,0 +1,31 @@
+//go:build !linux
+
+package scheduler
+
+import (
+	"errors"
+	"os/exec"
+
+	"github.com/shammishailaj/gronicle/pkg/monitor"
+	"github.com/shammishailaj/gronicle/pkg/storage"
+)
+
+// taskCgroup is the cgroup v2 leaf an attempt's command runs in. cgroups only exist on Linux.
+type taskCgroup struct{}
+
+// newTaskCgroup fails because resource limits need cgroup v2, which only exists on Linux.
+func newTaskCgroup(parent, name string, limits storage.ResourceLimits) (*taskCgroup, error) {
+	return nil, errors.New("resource limits require Linux cgroup v2")
+}
+
+func (c *taskCgroup) apply(cmd *exec.Cmd) {}
+
+func (c *taskCgroup) oomKills() int { return 0 }
+
+func (c *taskCgroup) usage() monitor.ResourceUsage { return monitor.ResourceUsage{} }
+
+func (c *taskCgroup) processMetrics() (monitor.ProcessMetrics, error) {
+	return monitor.ProcessMetrics{}, errors.New("cgroups only exist on Linux")
+}
+
+func (c *taskCgroup) remove() {}
@@ -0,0 +1,67 @@
+package scheduler
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"time"
+
+	"github.com/shammishailaj/gronicle/pkg/storage"
+)
+
+// leaseDuration is how long a claimed task stays reserved for this process without a renewal.
+// Workers renew the lease every leaseDuration/3 while the task runs.
+const leaseDuration = 5 * time.Minute
+
+// newLeaseOwner returns an identifier that is unique to this gronicle process.
+func newLeaseOwner() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return fmt.Sprintf("%s:%d:%08x", hostname, os.Getpid(), rand.Uint32())
+}
+
+// holdLease confirms that the task's claim still holds its lease and keeps renewing it until
+// the returned release function is called. It reports false if the lease expired or was claimed
+// again while the task waited in the queue, in which case the task must not be run.
+func holdLease(db *sql.DB, task *storage.Task) (func(), bool) {
+	owned, err := storage.RenewTaskLease(db, task, time.Now(), leaseDuration)
+	if err != nil {
+		log.Printf("Failed to renew lease for task %d: %v", task.ID, err)
+		return nil, false
+	}
+	if !owned {
+		return nil, false
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(leaseDuration / 3)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				owned, err := storage.RenewTaskLease(db, task, time.Now(), leaseDuration)
+				if err != nil {
+					log.Printf("Failed to renew lease for task %d: %v", task.ID, err)
+				} else if !owned {
+					log.Printf("Lease for task %d was lost while it was running", task.ID)
+				}
+			}
+		}
+	}()
+
+	release := func() {
+		close(done)
+		if err := storage.ReleaseTaskLease(db, task); err != nil {
+			log.Printf("Failed to release lease for task %d: %v", task.ID, err)
+		}
+	}
+	return release, true
+}
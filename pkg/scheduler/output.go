@@ -0,0 +1,325 @@
+package scheduler
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shammishailaj/gronicle/pkg/monitor"
+)
+
+// Output stream names.
+const (
+	StreamStdout = "stdout"
+	StreamStderr = "stderr"
+)
+
+// OutputLine is one line written by a task, tagged with the stream it came from and when it arrived.
+type OutputLine struct {
+	Stream string    `json:"stream"`
+	Text   string    `json:"text"`
+	Time   time.Time `json:"time"`
+}
+
+// String formats the line the way it appears in uploaded logs.
+func (l OutputLine) String() string {
+	return fmt.Sprintf("%s [%s] %s", l.Time.UTC().Format(time.RFC3339Nano), l.Stream, l.Text)
+}
+
+// commandResult is everything executeCommand learned about one attempt. The attempt's output is
+// in the outputCapture it was given.
+type commandResult struct {
+	ExitCode *int                  // nil if the command could not be started
+	Signal   string                // Name of the signal that terminated the command, if any
+	OOMKills int                   // Processes killed for exceeding the task's memory limit
+	Usage    monitor.ResourceUsage // What the command and its descendants consumed in total
+	Metrics  []monitor.ProcessMetrics
+	Err      error // nil only if the command exited with status 0
+}
+
+// exitSummary describes how the command exited, as the last line of its log.
+func (r *commandResult) exitSummary() string {
+	switch {
+	case r.ExitCode == nil:
+		return fmt.Sprintf("Command did not start: %v", r.Err)
+	case r.Signal != "":
+		return fmt.Sprintf("Command terminated by signal: %s", r.Signal)
+	default:
+		return fmt.Sprintf("Command exited with code: %d", *r.ExitCode)
+	}
+}
+
+// followChunkSize is roughly how much of a spool a follower reads at a time.
+const followChunkSize = 64 * 1024
+
+// outputCapture collects the lines of both streams of a running command and lets followers
+// wait for new ones. Lines are spooled to a temporary file in the OutputLine.String format,
+// which is also the format of uploaded logs, so output of any size is never held in memory.
+type outputCapture struct {
+	mu      sync.Mutex
+	spool   *os.File
+	size    int64         // Bytes of complete lines in spool
+	err     error         // First error writing to spool; later lines are dropped
+	changed chan struct{} // Closed and replaced whenever a line is added or the capture is closed
+	closed  bool
+
+	redactor *Redactor // Applied to each line before anything can read it
+}
+
+// newOutputCapture creates a capture spooling to a new temporary file, which remove deletes.
+func newOutputCapture(redactor *Redactor) (*outputCapture, error) {
+	spool, err := os.CreateTemp("", "gronicle-output-*.log")
+	if err != nil {
+		return nil, err
+	}
+	return &outputCapture{spool: spool, changed: make(chan struct{}), redactor: redactor}, nil
+}
+
+// add records a complete line, with any secrets in it redacted.
+func (c *outputCapture) add(stream, text string) {
+	line := OutputLine{Stream: stream, Text: c.redactor.Redact(text), Time: time.Now()}
+	c.write(line.String())
+}
+
+// addNote records a line that was not written by the command, such as how it exited.
+func (c *outputCapture) addNote(text string) {
+	c.write(text)
+}
+
+// write appends a line to the spool.
+func (c *outputCapture) write(text string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return
+	}
+
+	n, err := c.spool.WriteString(text + "\n")
+	if err != nil {
+		// A partly written line is left beyond size, where nothing reads it
+		log.Printf("Failed to spool output to %s, dropping the rest: %v", c.spool.Name(), err)
+		c.err = err
+		return
+	}
+	c.size += int64(n)
+	c.notify()
+}
+
+// close marks the capture complete; the command will add no more lines.
+func (c *outputCapture) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	c.notify()
+}
+
+// notify wakes up followers. c.mu must be held.
+func (c *outputCapture) notify() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// state returns how many bytes of complete lines the spool holds, a channel that is closed when
+// that changes, and whether the capture is complete.
+func (c *outputCapture) state() (int64, <-chan struct{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size, c.changed, c.closed
+}
+
+// read returns the lines spooled between offset and end, which must both be at the start of a
+// line, stopping after about followChunkSize bytes, and the offset after the last line returned.
+func (c *outputCapture) read(offset, end int64) ([]OutputLine, int64, error) {
+	reader := bufio.NewReader(io.NewSectionReader(c.spool, offset, end-offset))
+	var lines []OutputLine
+	for start := offset; offset < end && offset-start < followChunkSize; {
+		text, err := reader.ReadString('\n')
+		if err != nil {
+			return lines, offset, err
+		}
+		offset += int64(len(text))
+		lines = append(lines, ParseOutputLine(strings.TrimSuffix(text, "\n")))
+	}
+	return lines, offset, nil
+}
+
+// content returns the spooled lines for uploading.
+func (c *outputCapture) content() io.ReadSeeker {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return io.NewSectionReader(c.spool, 0, c.size)
+}
+
+// remove deletes the spool. Nothing may read the capture afterwards.
+func (c *outputCapture) remove() {
+	c.spool.Close()
+	if err := os.Remove(c.spool.Name()); err != nil {
+		log.Printf("Failed to remove output spool %s: %v", c.spool.Name(), err)
+	}
+}
+
+// LiveOutput is the output of an in-flight run, attempt by attempt, for following it in real time.
+// The attempts' spools are removed once the worker and every follower have released it.
+type LiveOutput struct {
+	mu       sync.Mutex
+	attempts []*outputCapture
+	changed  chan struct{} // Closed and replaced whenever an attempt starts or the run finishes
+	status   string        // Final run status, set once the run has finished
+	refs     int           // The worker's reference and one per follower
+}
+
+func newLiveOutput() *LiveOutput {
+	return &LiveOutput{changed: make(chan struct{}), refs: 1}
+}
+
+// acquire takes a reference for a follower.
+func (o *LiveOutput) acquire() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.refs++
+}
+
+// Release gives up a reference taken by WorkerPool.LiveOutput, or the worker's own. The last
+// release removes the attempts' spools, after which the output can no longer be followed.
+func (o *LiveOutput) Release() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.refs--; o.refs > 0 {
+		return
+	}
+	for _, capture := range o.attempts {
+		capture.remove()
+	}
+}
+
+// addAttempt starts following a new attempt's capture.
+func (o *LiveOutput) addAttempt(capture *outputCapture) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.attempts = append(o.attempts, capture)
+	close(o.changed)
+	o.changed = make(chan struct{})
+}
+
+// finish records the run's final status and releases followers.
+func (o *LiveOutput) finish(status string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.status = status
+	close(o.changed)
+	o.changed = make(chan struct{})
+}
+
+// state returns the attempts so far, a channel that is closed when that changes, and the
+// final status if the run has finished.
+func (o *LiveOutput) state() ([]*outputCapture, <-chan struct{}, string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]*outputCapture(nil), o.attempts...), o.changed, o.status
+}
+
+// Follow calls fn with every line of the run, starting from the beginning of the first
+// attempt, as the lines arrive. Each attempt ends with a line without a stream saying how its
+// command exited. Attempts are numbered from 1. It returns the run's final status once it has
+// finished, or early with ctx's error, an error reading the output or the first error from fn.
+func (o *LiveOutput) Follow(ctx context.Context, fn func(attempt int, line OutputLine) error) (string, error) {
+	attempt, offset := 0, int64(0)
+
+	for {
+		attempts, runChanged, status := o.state()
+
+		if attempt < len(attempts) {
+			size, changed, closed := attempts[attempt].state()
+			if offset < size {
+				lines, next, err := attempts[attempt].read(offset, size)
+				for _, line := range lines {
+					if err := fn(attempt+1, line); err != nil {
+						return "", err
+					}
+				}
+				if err != nil {
+					return "", err
+				}
+				offset = next
+				continue
+			}
+			if closed {
+				attempt, offset = attempt+1, 0
+				continue
+			}
+
+			select {
+			case <-changed:
+			case <-ctx.Done():
+				return "", ctx.Err()
+			}
+			continue
+		}
+
+		if status != "" {
+			return status, nil
+		}
+
+		select {
+		case <-runChanged:
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+}
+
+// ParseOutputLine parses a line of an uploaded log back into an OutputLine. Lines that are
+// not in the OutputLine.String format, such as the exit summary, are returned with an
+// empty stream.
+func ParseOutputLine(text string) OutputLine {
+	stamp, rest, ok := strings.Cut(text, " [")
+	if !ok {
+		return OutputLine{Text: text}
+	}
+	stream, body, ok := strings.Cut(rest, "] ")
+	if !ok || (stream != StreamStdout && stream != StreamStderr) {
+		return OutputLine{Text: text}
+	}
+	at, err := time.Parse(time.RFC3339Nano, stamp)
+	if err != nil {
+		return OutputLine{Text: text}
+	}
+	return OutputLine{Stream: stream, Text: body, Time: at}
+}
+
+// streamWriter splits what a command writes to one stream into lines as it arrives.
+// os/exec copies each stream from its own goroutine, so a streamWriter is never written
+// to concurrently.
+type streamWriter struct {
+	capture *outputCapture
+	stream  string
+	partial []byte
+}
+
+func (w *streamWriter) Write(p []byte) (int, error) {
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+		w.capture.add(w.stream, strings.TrimSuffix(string(w.partial[:i]), "\r"))
+		w.partial = w.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+// flush records a final line that was not terminated by a newline.
+func (w *streamWriter) flush() {
+	if len(w.partial) > 0 {
+		w.capture.add(w.stream, string(w.partial))
+		w.partial = nil
+	}
+}
@@ -0,0 +1,46 @@
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	"os/user"
+	"strconv"
+	"time"
+)
+
+// killGracePeriod is how long a process group gets to exit after SIGTERM before it is sent SIGKILL.
+const killGracePeriod = 10 * time.Second
+
+// errTimedOut is the cancellation cause for attempts that exceeded the task's timeout.
+var errTimedOut = errors.New("task exceeded its timeout")
+
+// lookupUser finds a user by name or UID. A UID with no account on this host is still accepted.
+func lookupUser(nameOrID string) (*user.User, error) {
+	if u, err := user.Lookup(nameOrID); err == nil {
+		return u, nil
+	}
+	if _, err := parseID(nameOrID); err != nil {
+		return nil, fmt.Errorf("unknown user %q", nameOrID)
+	}
+	if u, err := user.LookupId(nameOrID); err == nil {
+		return u, nil
+	}
+	return &user.User{Uid: nameOrID, Gid: nameOrID, Username: nameOrID, HomeDir: "/"}, nil
+}
+
+// lookupGroupID finds a group's GID by name or GID. A GID with no group on this host is still accepted.
+func lookupGroupID(nameOrID string) (uint32, error) {
+	if g, err := user.LookupGroup(nameOrID); err == nil {
+		return parseID(g.Gid)
+	}
+	if gid, err := parseID(nameOrID); err == nil {
+		return gid, nil
+	}
+	return 0, fmt.Errorf("unknown group %q", nameOrID)
+}
+
+// parseID parses a numeric UID or GID.
+func parseID(id string) (uint32, error) {
+	parsed, err := strconv.ParseUint(id, 10, 32)
+	return uint32(parsed), err
+}
@@ -0,0 +1,42 @@
+//go:build !unix
+
+package scheduler
+
+import (
+	"errors"
+	"log"
+	"os"
+	"os/exec"
+	"os/user"
+)
+
+// setProcessGroup does nothing: process groups only exist on Unix, so only the command itself
+// is stopped when a run is cancelled.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// setCredential fails if a user or group is given, as switching to them is only supported on Unix.
+func setCredential(cmd *exec.Cmd, runAsUser, runAsGroup string) (*user.User, error) {
+	if runAsUser == "" && runAsGroup == "" {
+		return nil, nil
+	}
+	return nil, errors.New("run_as_user and run_as_group are only supported on Unix")
+}
+
+// ValidateRunAs fails if a user or group is given, as switching to them is only supported on Unix.
+func ValidateRunAs(runAsUser, runAsGroup string) error {
+	_, err := setCredential(nil, runAsUser, runAsGroup)
+	return err
+}
+
+// killProcessGroup kills the command with the given PID right away, as there is no process group
+// to signal and no SIGTERM to give it a chance to exit on its own.
+func killProcessGroup(pid int) (stop func()) {
+	process, err := os.FindProcess(pid)
+	if err == nil {
+		err = process.Kill()
+	}
+	if err != nil && !errors.Is(err, os.ErrProcessDone) {
+		log.Printf("Failed to kill process %d: %v", pid, err)
+	}
+	return func() {}
+}
@@ -0,0 +1,113 @@
+//go:build unix
+
+package scheduler
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"os/user"
+	"syscall"
+	"time"
+)
+
+// setProcessGroup makes cmd the leader of a new process group, so the shell and every
+// process it spawns can be signalled together.
+func setProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+}
+
+// setCredential makes cmd run as runAsUser and runAsGroup, given as names or numeric IDs. An
+// empty runAsGroup uses the user's primary group and supplementary groups; an explicit group
+// is the only group the command gets. It returns the user cmd runs as, or nil if runAsUser is
+// empty. Switching to another user requires gronicle to run as root.
+func setCredential(cmd *exec.Cmd, runAsUser, runAsGroup string) (*user.User, error) {
+	if runAsUser == "" && runAsGroup == "" {
+		return nil, nil
+	}
+
+	credential := &syscall.Credential{Uid: uint32(os.Getuid()), Gid: uint32(os.Getgid())}
+
+	var runAs *user.User
+	if runAsUser != "" {
+		var err error
+		if runAs, err = lookupUser(runAsUser); err != nil {
+			return nil, err
+		}
+		uid, err := parseID(runAs.Uid)
+		if err != nil {
+			return nil, fmt.Errorf("user %s has non-numeric UID %q", runAsUser, runAs.Uid)
+		}
+		credential.Uid = uid
+		if gid, err := parseID(runAs.Gid); err == nil {
+			credential.Gid = gid
+		}
+
+		groupIDs, err := runAs.GroupIds()
+		if err != nil {
+			log.Printf("Failed to look up supplementary groups of %s: %v", runAsUser, err)
+		}
+		for _, groupID := range groupIDs {
+			if gid, err := parseID(groupID); err == nil {
+				credential.Groups = append(credential.Groups, gid)
+			}
+		}
+	}
+
+	if runAsGroup != "" {
+		gid, err := lookupGroupID(runAsGroup)
+		if err != nil {
+			return nil, err
+		}
+		credential.Gid = gid
+		credential.Groups = []uint32{gid}
+	}
+
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Credential = credential
+	return runAs, nil
+}
+
+// ValidateRunAs checks that the user and group a task runs as, given as names or numeric IDs, can
+// be looked up as setCredential will, so that a mistyped name is rejected when the task is created
+// rather than failing every run.
+func ValidateRunAs(runAsUser, runAsGroup string) error {
+	if runAsUser != "" {
+		runAs, err := lookupUser(runAsUser)
+		if err != nil {
+			return err
+		}
+		if _, err := parseID(runAs.Uid); err != nil {
+			return fmt.Errorf("user %s has non-numeric UID %q", runAsUser, runAs.Uid)
+		}
+	}
+	if runAsGroup != "" {
+		if _, err := lookupGroupID(runAsGroup); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// killProcessGroup sends SIGTERM to the process group led by pgid, then SIGKILL after
+// killGracePeriod to whatever is left of the group, including children that outlived the shell.
+// The returned function cancels the SIGKILL and must be called once the leader has been waited
+// for: the group's ID may be reused after that, and another group must never be killed.
+func killProcessGroup(pgid int) (stop func()) {
+	if err := syscall.Kill(-pgid, syscall.SIGTERM); err != nil && err != syscall.ESRCH {
+		log.Printf("Failed to send SIGTERM to process group %d: %v", pgid, err)
+	}
+
+	timer := time.AfterFunc(killGracePeriod, func() {
+		if err := syscall.Kill(-pgid, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+			log.Printf("Failed to send SIGKILL to process group %d: %v", pgid, err)
+		}
+	})
+	return func() { timer.Stop() }
+}
@@ -0,0 +1,40 @@
+//go:build unix
+
+package scheduler
+
+import (
+	"os/user"
+	"testing"
+)
+
+func TestValidateRunAs(t *testing.T) {
+	current, err := user.Current()
+	if err != nil {
+		t.Skipf("cannot look up the current user: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		runAsUser  string
+		runAsGroup string
+		wantErr    bool
+	}{
+		{name: "none"},
+		{name: "user by name", runAsUser: current.Username},
+		{name: "user by UID", runAsUser: current.Uid},
+		{name: "UID without an account", runAsUser: "54321"},
+		{name: "group by GID", runAsGroup: current.Gid},
+		{name: "GID without a group", runAsGroup: "54321"},
+		{name: "unknown user", runAsUser: "no-such-gronicle-user", wantErr: true},
+		{name: "unknown group", runAsGroup: "no-such-gronicle-group", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateRunAs(tt.runAsUser, tt.runAsGroup)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateRunAs(%q, %q) = %v, want error: %v", tt.runAsUser, tt.runAsGroup, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package scheduler
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// redactedText replaces every secret found in task output.
+const redactedText = "[REDACTED]"
+
+// minSecretLength is the shortest secret that is masked literally. Shorter values such as "1"
+// or "dev" would mask unrelated output.
+const minSecretLength = 4
+
+// Redactor masks secrets in task output before it is stored, streamed or logged. It replaces
+// literal secrets, such as the values of environment variables injected into a run, and
+// matches of regular expressions. A nil Redactor leaves text unchanged.
+type Redactor struct {
+	secrets  []string
+	literals *strings.Replacer // nil if there are no secrets
+	patterns []*regexp.Regexp
+}
+
+// CompileRedactPatterns compiles redaction patterns, reporting the first that is invalid.
+func CompileRedactPatterns(patterns []string) ([]*regexp.Regexp, error) {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redaction pattern %q: %w", pattern, err)
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+// NewRedactor creates a redactor that masks the literal secrets and the matches of patterns.
+func NewRedactor(literals []string, patterns []*regexp.Regexp) *Redactor {
+	r := &Redactor{patterns: patterns}
+	for _, literal := range literals {
+		if len(literal) >= minSecretLength {
+			r.secrets = append(r.secrets, literal)
+		}
+	}
+
+	if len(r.secrets) > 0 {
+		// Replace longer secrets first so that a secret containing another is masked whole
+		sort.SliceStable(r.secrets, func(i, j int) bool { return len(r.secrets[i]) > len(r.secrets[j]) })
+		pairs := make([]string, 0, 2*len(r.secrets))
+		for _, secret := range r.secrets {
+			pairs = append(pairs, secret, redactedText)
+		}
+		r.literals = strings.NewReplacer(pairs...)
+	}
+	return r
+}
+
+// secretEnvWords mark an environment variable as holding a secret when they are one of the
+// underscore-separated words of its name, as in GITHUB_TOKEN, API_KEY, DB_PASS or GCP_CREDENTIALS.
+var secretEnvWords = []string{"TOKEN", "SECRET", "PASSWORD", "PASSWD", "PASS", "KEY", "CREDENTIAL", "CREDENTIALS"}
+
+// isSecretEnv reports whether the environment variable name holds a secret: either the task lists
+// it in secretEnv or its name says so.
+func isSecretEnv(name string, secretEnv []string) bool {
+	for _, secret := range secretEnv {
+		if name == secret {
+			return true
+		}
+	}
+	for _, word := range strings.Split(strings.ToUpper(name), "_") {
+		for _, secretWord := range secretEnvWords {
+			if word == secretWord {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// MaskSecretEnv returns a copy of env with the values of the secret variables, as isSecretEnv picks
+// them, replaced by [REDACTED], for showing a task's environment without its secrets.
+func MaskSecretEnv(env map[string]string, secretEnv []string) map[string]string {
+	if env == nil {
+		return nil
+	}
+	masked := make(map[string]string, len(env))
+	for name, value := range env {
+		if isSecretEnv(name, secretEnv) {
+			value = redactedText
+		}
+		masked[name] = value
+	}
+	return masked
+}
+
+// jobRedactor extends the pool-wide redactor with the job's own rules: the task's redaction
+// patterns and the values of the secret environment variables the task and the run inject, as
+// isSecretEnv picks them from the names both the task and the run list as secret. Task patterns are validated when the task is created, so one that no
+// longer compiles is only logged.
+func jobRedactor(base *Redactor, j *job) *Redactor {
+	patterns, err := CompileRedactPatterns(j.task.RedactPatterns)
+	if err != nil {
+		log.Printf("Ignoring redaction patterns of task %d: %v", j.task.ID, err)
+		patterns = nil
+	}
+
+	secretEnv := append(append([]string{}, j.task.SecretEnv...), j.secretEnv...)
+	var literals []string
+	for _, env := range []map[string]string{j.task.Env, j.env} {
+		for name, value := range env {
+			if isSecretEnv(name, secretEnv) {
+				literals = append(literals, value)
+			}
+		}
+	}
+	return base.With(literals, patterns)
+}
+
+// With returns a redactor that also masks the given literal secrets and pattern matches.
+func (r *Redactor) With(literals []string, patterns []*regexp.Regexp) *Redactor {
+	if r == nil {
+		return NewRedactor(literals, patterns)
+	}
+	return NewRedactor(
+		append(append([]string{}, r.secrets...), literals...),
+		append(append([]*regexp.Regexp{}, r.patterns...), patterns...),
+	)
+}
+
+// Redact returns text with every secret replaced by [REDACTED].
+func (r *Redactor) Redact(text string) string {
+	if r == nil {
+		return text
+	}
+	if r.literals != nil {
+		text = r.literals.Replace(text)
+	}
+	for _, re := range r.patterns {
+		text = re.ReplaceAllString(text, redactedText)
+	}
+	return text
+}
@@ -0,0 +1,139 @@
+package scheduler
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/shammishailaj/gronicle/pkg/storage"
+)
+
+func TestIsSecretEnv(t *testing.T) {
+	tests := []struct {
+		name      string
+		secretEnv []string
+		want      bool
+	}{
+		{name: "GITHUB_TOKEN", want: true},
+		{name: "AWS_SECRET_ACCESS_KEY", want: true},
+		{name: "API_KEY", want: true},
+		{name: "PRIVATE_KEY", want: true},
+		{name: "DB_PASSWORD", want: true},
+		{name: "DB_PASSWD", want: true},
+		{name: "DB_PASS", want: true},
+		{name: "GCP_CREDENTIALS", want: true},
+		{name: "SERVICE_CREDENTIAL", want: true},
+		{name: "secret", want: true},
+		{name: "api_key", want: true},
+		{name: "REGISTRY_AUTH", secretEnv: []string{"REGISTRY_AUTH"}, want: true},
+		{name: "REGISTRY_AUTH", want: false},
+		{name: "EXPORT_BUCKET", want: false},
+		{name: "PASSPORT_DIR", want: false}, // Words are matched whole
+		{name: "MONKEY", want: false},
+		{name: "TOKENS_PER_MINUTE", want: false},
+		{name: "registry_auth", secretEnv: []string{"REGISTRY_AUTH"}, want: false}, // Listed names are matched exactly
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isSecretEnv(tt.name, tt.secretEnv); got != tt.want {
+				t.Errorf("isSecretEnv(%q, %q) = %v, want %v", tt.name, tt.secretEnv, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedactorRedact(t *testing.T) {
+	tests := []struct {
+		name     string
+		redactor *Redactor
+		text     string
+		want     string
+	}{
+		{
+			name:     "nil redactor",
+			redactor: nil,
+			text:     "token s3cr3t",
+			want:     "token s3cr3t",
+		},
+		{
+			name:     "literal",
+			redactor: NewRedactor([]string{"s3cr3t"}, nil),
+			text:     "token s3cr3t and s3cr3t again",
+			want:     "token [REDACTED] and [REDACTED] again",
+		},
+		{
+			name:     "short literals are not masked",
+			redactor: NewRedactor([]string{"dev", "1"}, nil),
+			text:     "env dev 1",
+			want:     "env dev 1",
+		},
+		{
+			name:     "longer literal is masked whole",
+			redactor: NewRedactor([]string{"abcd", "abcdefgh"}, nil),
+			text:     "abcdefgh abcd",
+			want:     "[REDACTED] [REDACTED]",
+		},
+		{
+			name:     "pattern",
+			redactor: NewRedactor(nil, []*regexp.Regexp{regexp.MustCompile(`ghp_[A-Za-z0-9]{4}`)}),
+			text:     "cloning with ghp_Ab12",
+			want:     "cloning with [REDACTED]",
+		},
+		{
+			name:     "with adds to the base rules",
+			redactor: NewRedactor([]string{"base-secret"}, nil).With([]string{"run-secret"}, []*regexp.Regexp{regexp.MustCompile(`\d{6}`)}),
+			text:     "base-secret run-secret 123456",
+			want:     "[REDACTED] [REDACTED] [REDACTED]",
+		},
+		{
+			name:     "with on a nil redactor",
+			redactor: (*Redactor)(nil).With([]string{"run-secret"}, nil),
+			text:     "run-secret",
+			want:     "[REDACTED]",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.redactor.Redact(tt.text); got != tt.want {
+				t.Errorf("Redact(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaskSecretEnv(t *testing.T) {
+	env := map[string]string{"API_KEY": "abcd1234", "REGISTRY_AUTH": "dXNlcjpwYXNz", "DEPLOY_ENV": "production"}
+
+	got := MaskSecretEnv(env, []string{"REGISTRY_AUTH"})
+	want := map[string]string{"API_KEY": "[REDACTED]", "REGISTRY_AUTH": "[REDACTED]", "DEPLOY_ENV": "production"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("MaskSecretEnv = %v, want %v", got, want)
+	}
+	if env["API_KEY"] != "abcd1234" {
+		t.Errorf("MaskSecretEnv changed its argument: %v", env)
+	}
+	if got := MaskSecretEnv(nil, nil); got != nil {
+		t.Errorf("MaskSecretEnv(nil) = %v, want nil", got)
+	}
+}
+
+func TestJobRedactor(t *testing.T) {
+	j := &job{
+		task: &storage.Task{
+			RedactPatterns: storage.StringList{`id-\d+`},
+			Env:            storage.StringMap{"GITHUB_TOKEN": "task-token", "REGISTRY_AUTH": "task-auth", "DEPLOY_ENV": "production"},
+			SecretEnv:      storage.StringList{"REGISTRY_AUTH"},
+		},
+		env:       map[string]string{"DB_PASS": "run-pass", "UPSTREAM_AUTH": "run-auth", "REGION": "eu-west-1"},
+		secretEnv: []string{"UPSTREAM_AUTH"},
+	}
+	redactor := jobRedactor(NewRedactor([]string{"global-secret"}, nil), j)
+
+	text := "task-token task-auth production run-pass run-auth eu-west-1 id-42 global-secret"
+	want := "[REDACTED] [REDACTED] production [REDACTED] [REDACTED] eu-west-1 [REDACTED] [REDACTED]"
+	if got := redactor.Redact(text); got != want {
+		t.Errorf("Redact(%q) = %q, want %q", text, got, want)
+	}
+}
@@ -0,0 +1,107 @@
+package scheduler
+
+import (
+	"log"
+	"time"
+
+	"github.com/shammishailaj/gronicle/pkg/monitor"
+)
+
+// DefaultSampleInterval is how often a task's processes are sampled when neither the task nor
+// SamplerOptions set an interval.
+const DefaultSampleInterval = time.Second
+
+// SamplerOptions configures how a task's process tree is sampled while an attempt runs.
+type SamplerOptions struct {
+	Interval   time.Duration // Time between samples; a task's own sample interval takes precedence
+	MaxSamples int           // Samples kept per attempt, the most recent ones; 0 keeps all of them
+}
+
+// forTask returns the options with the task's own sample interval, if it has one, applied.
+func (o SamplerOptions) forTask(sampleIntervalSeconds int) SamplerOptions {
+	if sampleIntervalSeconds > 0 {
+		o.Interval = time.Duration(sampleIntervalSeconds) * time.Second
+	}
+	if o.Interval <= 0 {
+		o.Interval = DefaultSampleInterval
+	}
+	return o
+}
+
+// processSampler samples a running command's process tree in the background. The first sample
+// is taken as soon as it starts, so even a command that exits within the first interval has one.
+// The samples are handed back over a channel once sampling stops, so they are never shared with
+// the goroutine taking them.
+type processSampler struct {
+	stop    chan struct{}
+	results chan []monitor.ProcessMetrics
+}
+
+// startProcessSampler starts sampling the processes of the command with the given PID, each
+// sample taken by collect.
+func startProcessSampler(pid int, collect func() (monitor.ProcessMetrics, error), opts SamplerOptions) *processSampler {
+	s := &processSampler{
+		stop:    make(chan struct{}),
+		results: make(chan []monitor.ProcessMetrics, 1),
+	}
+	go s.run(pid, collect, opts)
+	return s
+}
+
+func (s *processSampler) run(pid int, collect func() (monitor.ProcessMetrics, error), opts SamplerOptions) {
+	ring := newSampleRing(opts.MaxSamples)
+	ticker := time.NewTicker(opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		// The process is gone once it has exited and been waited for, so a failed sample is skipped
+		if sample, err := collect(); err == nil {
+			ring.add(sample)
+		}
+
+		select {
+		case <-s.stop:
+			if ring.overwritten > 0 {
+				log.Printf("Kept the last %d samples of PID %d, discarding %d older ones", len(ring.buf), pid, ring.overwritten)
+			}
+			s.results <- ring.samples()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Stop stops sampling and returns the samples taken, oldest first. It must be called exactly once.
+func (s *processSampler) Stop() []monitor.ProcessMetrics {
+	close(s.stop)
+	return <-s.results
+}
+
+// sampleRing holds the most recent samples up to a capacity, overwriting the oldest ones when full.
+type sampleRing struct {
+	buf         []monitor.ProcessMetrics
+	capacity    int // 0 for no limit
+	next        int // Index of the oldest sample, which the next one overwrites once full
+	overwritten int
+}
+
+func newSampleRing(capacity int) *sampleRing {
+	return &sampleRing{capacity: max(capacity, 0)}
+}
+
+func (r *sampleRing) add(sample monitor.ProcessMetrics) {
+	if r.capacity == 0 || len(r.buf) < r.capacity {
+		r.buf = append(r.buf, sample)
+		return
+	}
+	r.buf[r.next] = sample
+	r.next = (r.next + 1) % r.capacity
+	r.overwritten++
+}
+
+// samples returns a copy of the samples, oldest first.
+func (r *sampleRing) samples() []monitor.ProcessMetrics {
+	samples := make([]monitor.ProcessMetrics, 0, len(r.buf))
+	samples = append(samples, r.buf[r.next:]...)
+	return append(samples, r.buf[:r.next]...)
+}
@@ -0,0 +1,141 @@
+package scheduler
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/shammishailaj/gronicle/pkg/monitor"
+)
+
+// rssSequence returns the RSSBytes of each sample, which the tests use to number them.
+func rssSequence(samples []monitor.ProcessMetrics) []int64 {
+	var seq []int64
+	for _, sample := range samples {
+		seq = append(seq, sample.RSSBytes)
+	}
+	return seq
+}
+
+func TestSampleRing(t *testing.T) {
+	tests := []struct {
+		name            string
+		capacity        int
+		added           int
+		want            []int64
+		wantOverwritten int
+	}{
+		{name: "empty", capacity: 3, added: 0, want: nil},
+		{name: "below capacity", capacity: 3, added: 2, want: []int64{1, 2}},
+		{name: "at capacity", capacity: 3, added: 3, want: []int64{1, 2, 3}},
+		{name: "overwritten once", capacity: 3, added: 4, want: []int64{2, 3, 4}, wantOverwritten: 1},
+		{name: "wrapped around", capacity: 3, added: 7, want: []int64{5, 6, 7}, wantOverwritten: 4},
+		{name: "capacity of one", capacity: 1, added: 5, want: []int64{5}, wantOverwritten: 4},
+		{name: "no limit", capacity: 0, added: 5, want: []int64{1, 2, 3, 4, 5}},
+		{name: "negative capacity is no limit", capacity: -1, added: 2, want: []int64{1, 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ring := newSampleRing(tt.capacity)
+			for i := 1; i <= tt.added; i++ {
+				ring.add(monitor.ProcessMetrics{RSSBytes: int64(i)})
+			}
+
+			got := rssSequence(ring.samples())
+			if len(got) != len(tt.want) {
+				t.Fatalf("samples() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("samples() = %v, want %v", got, tt.want)
+				}
+			}
+			if ring.overwritten != tt.wantOverwritten {
+				t.Errorf("overwritten = %d, want %d", ring.overwritten, tt.wantOverwritten)
+			}
+		})
+	}
+}
+
+func TestSampleRingSamplesIsACopy(t *testing.T) {
+	ring := newSampleRing(2)
+	ring.add(monitor.ProcessMetrics{RSSBytes: 1})
+	ring.add(monitor.ProcessMetrics{RSSBytes: 2})
+
+	samples := ring.samples()
+	ring.add(monitor.ProcessMetrics{RSSBytes: 3})
+	if got := rssSequence(samples); got[0] != 1 || got[1] != 2 {
+		t.Errorf("samples() = %v after another add, want [1 2]", got)
+	}
+}
+
+// TestProcessSamplerConcurrent runs several samplers at once and stops them while they are
+// sampling, so that -race catches samples shared between the sampling goroutine and Stop.
+func TestProcessSamplerConcurrent(t *testing.T) {
+	const (
+		samplers   = 8
+		maxSamples = 5
+	)
+
+	var wg sync.WaitGroup
+	for i := 0; i < samplers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			var calls atomic.Int64
+			collect := func() (monitor.ProcessMetrics, error) {
+				n := calls.Add(1)
+				if n%4 == 0 {
+					// A failed sample, as when the process has just exited, is skipped
+					return monitor.ProcessMetrics{}, errors.New("process not running")
+				}
+				return monitor.ProcessMetrics{RSSBytes: n}, nil
+			}
+
+			s := startProcessSampler(0, collect, SamplerOptions{Interval: time.Millisecond, MaxSamples: maxSamples})
+			for deadline := time.Now().Add(5 * time.Second); calls.Load() < 4*maxSamples && time.Now().Before(deadline); {
+				time.Sleep(time.Millisecond)
+			}
+			samples := s.Stop()
+			total := calls.Load()
+
+			got := rssSequence(samples)
+			if len(got) != maxSamples {
+				t.Errorf("Stop() after %d samples returned %v, want the last %d", total, got, maxSamples)
+				return
+			}
+			for j, seq := range got {
+				if seq%4 == 0 {
+					t.Errorf("Stop() = %v kept a failed sample", got)
+				}
+				if j > 0 && seq <= got[j-1] {
+					t.Errorf("Stop() = %v, want the samples oldest first", got)
+				}
+			}
+			// Nothing is sampled once Stop returns, so the most recent successful sample is the last one
+			last := total
+			if last%4 == 0 {
+				last--
+			}
+			if got[len(got)-1] != last {
+				t.Errorf("Stop() = %v after %d calls, want it to end with sample %d", got, total, last)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestProcessSamplerStopsBeforeFirstTick(t *testing.T) {
+	collect := func() (monitor.ProcessMetrics, error) {
+		return monitor.ProcessMetrics{RSSBytes: 1}, nil
+	}
+
+	s := startProcessSampler(0, collect, SamplerOptions{Interval: time.Hour})
+	if got := rssSequence(s.Stop()); len(got) != 1 || got[0] != 1 {
+		t.Errorf("Stop() before the first tick = %v, want the sample taken on start", got)
+	}
+}
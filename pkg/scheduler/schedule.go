@@ -0,0 +1,89 @@
+package scheduler
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/shammishailaj/gronicle/pkg/storage"
+)
+
+// cronParser accepts standard 5-field expressions, 6-field expressions with a leading
+// seconds field, and descriptors such as @hourly, @daily and @every 90m.
+var cronParser = cron.NewParser(cron.SecondOptional | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+// ParseSchedule parses a cron expression and evaluates it in the given IANA timezone.
+// An empty timezone means UTC.
+func ParseSchedule(spec, timezone string) (cron.Schedule, error) {
+	if timezone == "" {
+		timezone = "UTC"
+	}
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timezone %q: %w", timezone, err)
+	}
+
+	schedule, err := cronParser.Parse(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schedule %q: %w", spec, err)
+	}
+
+	// Fire times are computed on the wall clock of loc, so DST transitions are
+	// handled the way crontab does: skipped times do not fire and repeated ones fire once.
+	if specSchedule, ok := schedule.(*cron.SpecSchedule); ok {
+		specSchedule.Location = loc
+	}
+	return schedule, nil
+}
+
+// FirstRunAt computes when a newly created task should first run. Interval and
+// one-shot tasks run immediately, cron tasks wait for their first fire time. The
+// task's timezone must be valid either way.
+func FirstRunAt(task *storage.Task, now time.Time) (*time.Time, error) {
+	if task.Schedule == "" {
+		if task.Timezone != "" {
+			if _, err := time.LoadLocation(task.Timezone); err != nil {
+				return nil, fmt.Errorf("invalid timezone %q: %w", task.Timezone, err)
+			}
+		}
+		return &now, nil
+	}
+
+	schedule, err := ParseSchedule(task.Schedule, task.Timezone)
+	if err != nil {
+		return nil, err
+	}
+	next := schedule.Next(now)
+	return &next, nil
+}
+
+// NextRunAt computes when a task should run after the run that was due at task.NextRunAt.
+// Interval runs stay anchored to the original due time so they do not drift, and runs
+// missed while gronicle was down are skipped rather than replayed. A nil result means
+// the task does not recur.
+func NextRunAt(task *storage.Task, now time.Time) (*time.Time, error) {
+	if task.Schedule != "" {
+		schedule, err := ParseSchedule(task.Schedule, task.Timezone)
+		if err != nil {
+			return nil, err
+		}
+		next := schedule.Next(now)
+		return &next, nil
+	}
+
+	if task.Interval <= 0 {
+		return nil, nil
+	}
+
+	due := now
+	if task.NextRunAt != nil {
+		due = *task.NextRunAt
+	}
+
+	next := due.Add(task.Interval)
+	if !next.After(now) {
+		missed := now.Sub(due) / task.Interval
+		next = due.Add((missed + 1) * task.Interval)
+	}
+	return &next, nil
+}
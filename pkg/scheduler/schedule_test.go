@@ -0,0 +1,205 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata" // The timezones below must not depend on the host's zoneinfo
+
+	"github.com/shammishailaj/gronicle/pkg/storage"
+)
+
+func mustLoadLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatalf("loading %s: %v", name, err)
+	}
+	return loc
+}
+
+func TestParseScheduleNext(t *testing.T) {
+	newYork := mustLoadLocation(t, "America/New_York")
+	kolkata := mustLoadLocation(t, "Asia/Kolkata")
+
+	tests := []struct {
+		name     string
+		spec     string
+		timezone string
+		from     time.Time
+		want     time.Time
+	}{
+		{
+			name:     "spring forward skips the missing 02:30",
+			spec:     "30 2 * * *",
+			timezone: "America/New_York",
+			from:     time.Date(2024, 3, 9, 3, 0, 0, 0, newYork),
+			want:     time.Date(2024, 3, 11, 2, 30, 0, 0, newYork),
+		},
+		{
+			name:     "fall back fires the repeated 01:30 once",
+			spec:     "30 1 * * *",
+			timezone: "America/New_York",
+			from:     time.Date(2024, 11, 3, 0, 0, 0, 0, newYork),
+			want:     time.Date(2024, 11, 3, 1, 30, 0, 0, newYork),
+		},
+		{
+			// Monday 02:30 in Kolkata is still Sunday in UTC
+			name:     "weekdays are evaluated in the timezone",
+			spec:     "30 2 * * 1-5",
+			timezone: "Asia/Kolkata",
+			from:     time.Date(2024, 6, 7, 3, 0, 0, 0, kolkata),
+			want:     time.Date(2024, 6, 10, 2, 30, 0, 0, kolkata),
+		},
+		{
+			name:     "empty timezone is UTC",
+			spec:     "0 9 * * *",
+			timezone: "",
+			from:     time.Date(2024, 6, 7, 10, 0, 0, 0, time.UTC),
+			want:     time.Date(2024, 6, 8, 9, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "six fields with seconds",
+			spec:     "*/15 * * * * *",
+			timezone: "UTC",
+			from:     time.Date(2024, 6, 7, 10, 0, 7, 0, time.UTC),
+			want:     time.Date(2024, 6, 7, 10, 0, 15, 0, time.UTC),
+		},
+		{
+			name:     "hourly descriptor",
+			spec:     "@hourly",
+			timezone: "UTC",
+			from:     time.Date(2024, 6, 7, 10, 20, 0, 0, time.UTC),
+			want:     time.Date(2024, 6, 7, 11, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "daily descriptor in a timezone",
+			spec:     "@daily",
+			timezone: "Asia/Kolkata",
+			from:     time.Date(2024, 6, 7, 10, 20, 0, 0, kolkata),
+			want:     time.Date(2024, 6, 8, 0, 0, 0, 0, kolkata),
+		},
+		{
+			name:     "every descriptor",
+			spec:     "@every 90m",
+			timezone: "UTC",
+			from:     time.Date(2024, 6, 7, 10, 20, 0, 0, time.UTC),
+			want:     time.Date(2024, 6, 7, 11, 50, 0, 0, time.UTC),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule, err := ParseSchedule(tt.spec, tt.timezone)
+			if err != nil {
+				t.Fatalf("ParseSchedule(%q, %q): %v", tt.spec, tt.timezone, err)
+			}
+			if got := schedule.Next(tt.from); !got.Equal(tt.want) {
+				t.Errorf("Next(%s) = %s, want %s", tt.from, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseScheduleInvalid(t *testing.T) {
+	tests := []struct {
+		name     string
+		spec     string
+		timezone string
+	}{
+		{"unknown timezone", "0 9 * * *", "Mars/Olympus_Mons"},
+		{"too few fields", "0 9 *", "UTC"},
+		{"out of range", "0 25 * * *", "UTC"},
+		{"unknown descriptor", "@fortnightly", "UTC"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseSchedule(tt.spec, tt.timezone); err == nil {
+				t.Errorf("ParseSchedule(%q, %q) succeeded, want an error", tt.spec, tt.timezone)
+			}
+		})
+	}
+}
+
+func TestNextRunAt(t *testing.T) {
+	at := func(hour, minute int) time.Time { return time.Date(2024, 6, 7, hour, minute, 0, 0, time.UTC) }
+	ptr := func(t time.Time) *time.Time { return &t }
+
+	tests := []struct {
+		name string
+		task storage.Task
+		now  time.Time
+		want *time.Time
+	}{
+		{
+			name: "interval stays anchored to the due time",
+			task: storage.Task{Interval: 10 * time.Minute, NextRunAt: ptr(at(10, 0))},
+			now:  at(10, 1),
+			want: ptr(at(10, 10)),
+		},
+		{
+			name: "runs missed during downtime are skipped",
+			task: storage.Task{Interval: 10 * time.Minute, NextRunAt: ptr(at(10, 0))},
+			now:  at(10, 35),
+			want: ptr(at(10, 40)),
+		},
+		{
+			name: "downtime of many days catches up to the next slot",
+			task: storage.Task{Interval: time.Hour, NextRunAt: ptr(at(10, 0))},
+			now:  at(10, 0).Add(72*time.Hour + 30*time.Minute),
+			want: ptr(at(11, 0).Add(72 * time.Hour)),
+		},
+		{
+			name: "interval without a due time starts from now",
+			task: storage.Task{Interval: 10 * time.Minute},
+			now:  at(10, 3),
+			want: ptr(at(10, 13)),
+		},
+		{
+			name: "one-shot task does not recur",
+			task: storage.Task{NextRunAt: ptr(at(10, 0))},
+			now:  at(10, 1),
+			want: nil,
+		},
+		{
+			name: "cron schedule takes precedence over the interval",
+			task: storage.Task{Schedule: "@hourly", Interval: 10 * time.Minute, NextRunAt: ptr(at(10, 0))},
+			now:  at(10, 35),
+			want: ptr(at(11, 0)),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NextRunAt(&tt.task, tt.now)
+			if err != nil {
+				t.Fatalf("NextRunAt: %v", err)
+			}
+			switch {
+			case got == nil && tt.want == nil:
+			case got == nil || tt.want == nil:
+				t.Errorf("NextRunAt = %v, want %v", got, tt.want)
+			case !got.Equal(*tt.want):
+				t.Errorf("NextRunAt = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFirstRunAt(t *testing.T) {
+	now := time.Date(2024, 6, 7, 10, 20, 0, 0, time.UTC)
+
+	got, err := FirstRunAt(&storage.Task{Interval: time.Minute}, now)
+	if err != nil || !got.Equal(now) {
+		t.Errorf("interval task: FirstRunAt = %v, %v, want %s", got, err, now)
+	}
+
+	got, err = FirstRunAt(&storage.Task{Schedule: "@hourly"}, now)
+	if want := now.Truncate(time.Hour).Add(time.Hour); err != nil || !got.Equal(want) {
+		t.Errorf("cron task: FirstRunAt = %v, %v, want %s", got, err, want)
+	}
+
+	if got, err := FirstRunAt(&storage.Task{Interval: time.Minute, Timezone: "Mars/Olympus_Mons"}, now); err == nil {
+		t.Errorf("interval task with an invalid timezone: FirstRunAt = %v, want an error", got)
+	}
+}
@@ -3,6 +3,7 @@ package scheduler
 import (
 	"database/sql"
 	"log"
+	"sync"
 	"time"
 
 	"github.com/shammishailaj/gronicle/pkg/storage"
@@ -12,33 +13,63 @@ type Scheduler struct {
 	WorkerPool   *WorkerPool
 	db           *sql.DB
 	pollInterval time.Duration
+	leaseOwner   string
+	stopPolling  chan struct{}
+	stopOnce     sync.Once
+	polling      sync.WaitGroup
 }
 
-// NewSchedulerWithDB initializes a scheduler with a database connection and worker pool.
-func NewSchedulerWithDB(db *sql.DB, workerCount int, retryLimit int, pollInterval time.Duration) *Scheduler {
+// NewSchedulerWithDB initializes a scheduler with a database connection and a worker pool
+// that stores task logs in logSink after masking the secrets redactor finds in them. Tasks
+// with resource limits run in cgroups created under cgroupParent, and metrics, including the
+// samples of running tasks' processes taken as sampling says, are stored through metrics.
+func NewSchedulerWithDB(db *sql.DB, workerCount int, retryLimit int, pollInterval time.Duration, logSink storage.LogSink, redactor *Redactor, cgroupParent string, metrics *storage.MetricsWriter, sampling SamplerOptions) *Scheduler {
 	return &Scheduler{
 		db:           db,
-		WorkerPool:   NewWorkerPool(workerCount, retryLimit, nil),
+		WorkerPool:   NewWorkerPool(workerCount, retryLimit, logSink, redactor, cgroupParent, metrics, sampling),
 		pollInterval: pollInterval,
+		leaseOwner:   newLeaseOwner(),
+		stopPolling:  make(chan struct{}),
 	}
 }
 
-// LoadTasksFromDB continuously polls the database and adds pending tasks to the worker pool.
+// LoadTasksFromDB continuously polls the database, claims due tasks and adds them to the
+// worker pool. Claiming leases each task to this scheduler and advances its next_run_at,
+// so a task is queued once per due run even when several gronicle processes share the
+// database or a previous run is still in progress. Only as many tasks are claimed as there
+// are idle workers, so a claimed task starts, and renews its lease, before the lease expires.
 func (s *Scheduler) LoadTasksFromDB() {
+	s.polling.Add(1)
 	go func() {
+		defer s.polling.Done()
+
 		for {
-			log.Println("Polling database for new tasks...")
+			log.Println("Polling database for due tasks...")
 
-			tasks, err := storage.FetchPendingTasks(s.db)
+			now := time.Now()
+			tasks, err := storage.ClaimDueTasks(s.db, s.leaseOwner, now, leaseDuration, s.WorkerPool.IdleWorkers(), func(task *storage.Task) *time.Time {
+				next, err := NextRunAt(task, now)
+				if err != nil {
+					// A schedule that no longer parses would fire on every poll, so unschedule it.
+					log.Printf("Invalid schedule for task %d, unscheduling it: %v", task.ID, err)
+				}
+				return next
+			})
 			if err != nil {
-				log.Printf("Error fetching tasks: %v", err)
+				log.Printf("Error claiming tasks: %v", err)
 			} else {
 				for _, task := range tasks {
 					s.WorkerPool.AddTask(&task)
 				}
 			}
 
-			time.Sleep(s.pollInterval) // Wait before polling again
+			// Wait before polling again
+			select {
+			case <-s.stopPolling:
+				log.Println("Stopped polling for tasks.")
+				return
+			case <-time.After(s.pollInterval):
+			}
 		}
 	}()
 }
@@ -46,11 +77,20 @@ func (s *Scheduler) LoadTasksFromDB() {
 // Start begins task processing using the worker pool.
 func (s *Scheduler) Start(db *sql.DB) {
 	log.Println("Starting Scheduler...")
-	go s.WorkerPool.Start(db) // Start the worker pool
+	s.WorkerPool.Start(db) // Start the worker pool
+}
+
+// StopPolling stops claiming new tasks and waits for an in-progress poll to finish.
+// It is safe to call more than once.
+func (s *Scheduler) StopPolling() {
+	s.stopOnce.Do(func() { close(s.stopPolling) })
+	s.polling.Wait()
 }
 
-// Stop stops the worker pool.
-func (s *Scheduler) Stop() {
-	s.WorkerPool.Stop()
+// Stop stops polling and then stops the worker pool, giving in-flight runs up to
+// drainTimeout to finish before they are interrupted.
+func (s *Scheduler) Stop(drainTimeout time.Duration) {
+	s.StopPolling()
+	s.WorkerPool.Stop(drainTimeout)
 	log.Println("Scheduler stopped.")
 }
@@ -0,0 +1,30 @@
+package scheduler
+
+import (
+	"os"
+
+	"github.com/shammishailaj/gronicle/pkg/monitor"
+)
+
+// commandUsage totals what an attempt's processes consumed. The command's rusage covers it and
+// every descendant that was waited for, which the kernel adds up as they exit. The largest tree
+// RSS seen in samples is the better peak when processes ran side by side, as rusage only keeps
+// the largest single process's. A cgroup, when the task has one, also accounts for processes
+// left running in the background, so the larger figure is kept wherever it has one.
+func commandUsage(state *os.ProcessState, samples []monitor.ProcessMetrics, cgroup *taskCgroup) monitor.ResourceUsage {
+	usage := processUsage(state)
+
+	for _, sample := range samples {
+		usage.PeakRSSBytes = max(usage.PeakRSSBytes, sample.RSSBytes)
+	}
+
+	if cgroup != nil {
+		cgroupUsage := cgroup.usage()
+		usage.CPUUserSeconds = max(usage.CPUUserSeconds, cgroupUsage.CPUUserSeconds)
+		usage.CPUSystemSeconds = max(usage.CPUSystemSeconds, cgroupUsage.CPUSystemSeconds)
+		usage.PeakRSSBytes = max(usage.PeakRSSBytes, cgroupUsage.PeakRSSBytes)
+		usage.ReadBytes = max(usage.ReadBytes, cgroupUsage.ReadBytes)
+		usage.WriteBytes = max(usage.WriteBytes, cgroupUsage.WriteBytes)
+	}
+	return usage
+}
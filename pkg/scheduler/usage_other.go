@@ -0,0 +1,18 @@
+//go:build !unix
+
+package scheduler
+
+import (
+	"os"
+
+	"github.com/shammishailaj/gronicle/pkg/monitor"
+)
+
+// processUsage returns the CPU time the command consumed. Without rusage the rest is only known
+// from samples and cgroups.
+func processUsage(state *os.ProcessState) monitor.ResourceUsage {
+	return monitor.ResourceUsage{
+		CPUUserSeconds:   state.UserTime().Seconds(),
+		CPUSystemSeconds: state.SystemTime().Seconds(),
+	}
+}
@@ -0,0 +1,37 @@
+//go:build unix
+
+package scheduler
+
+import (
+	"os"
+	"runtime"
+	"syscall"
+
+	"github.com/shammishailaj/gronicle/pkg/monitor"
+)
+
+// blockSize is the unit of the block input and output counts in rusage.
+const blockSize = 512
+
+// processUsage returns what the command and the descendants it waited for consumed, from its rusage.
+func processUsage(state *os.ProcessState) monitor.ResourceUsage {
+	var usage monitor.ResourceUsage
+	if rusage, ok := state.SysUsage().(*syscall.Rusage); ok {
+		usage.CPUUserSeconds = timevalSeconds(rusage.Utime)
+		usage.CPUSystemSeconds = timevalSeconds(rusage.Stime)
+		usage.PeakRSSBytes = int64(rusage.Maxrss)
+		if runtime.GOOS != "darwin" {
+			usage.PeakRSSBytes *= 1024 // Kilobytes everywhere but macOS
+		}
+		usage.ReadBytes = int64(rusage.Inblock) * blockSize
+		usage.WriteBytes = int64(rusage.Oublock) * blockSize
+		usage.VoluntaryCtxSwitches = int64(rusage.Nvcsw)
+		usage.InvoluntaryCtxSwitches = int64(rusage.Nivcsw)
+	}
+	return usage
+}
+
+// timevalSeconds converts a rusage time to seconds.
+func timevalSeconds(tv syscall.Timeval) float64 {
+	return float64(tv.Sec) + float64(tv.Usec)/1e6
+}
@@ -1,54 +1,195 @@
 package scheduler
 
 import (
-	"github.com/shammishailaj/gronicle/pkg/monitor"
-	"github.com/shammishailaj/gronicle/pkg/storage"
+	"context"
+	"errors"
+	"fmt"
 	"log"
+	"os"
 	"os/exec"
+	"os/user"
+	"sort"
+	"strings"
+	"syscall"
 	"time"
+
+	"github.com/shammishailaj/gronicle/pkg/monitor"
 )
 
-// executeCommand runs a system command for the task.
-func executeCommand(task *storage.Task) (string, []monitor.ProcessMetrics, error) {
-	var (
-		output             []byte
-		inExecutionMetrics []monitor.ProcessMetrics
-		outputErr          error
-	)
-	cmd := exec.Command("/bin/sh", "-c", task.Command)
+// outputWaitDelay bounds how long executeCommand waits for a command's output after it exits,
+// for background processes that keep stdout or stderr open.
+const outputWaitDelay = 5 * time.Second
+
+// executeCommand runs a system command for the task in its own process group, capturing
+// stdout and stderr line by line into capture while it runs. When ctx is cancelled or its
+// deadline passes, the whole group is terminated. A task with resource limits runs in a cgroup
+// of its own under cgroupParent, named after the attempt, which is removed along with anything
+// left running in it once the command exits. capture ends with how the command exited and is
+// closed before returning.
+func executeCommand(ctx context.Context, j *job, cgroupParent string, attempt int, capture *outputCapture) *commandResult {
+	task := j.task
+	result := &commandResult{}
+	defer func() {
+		capture.addNote(result.exitSummary())
+		capture.close()
+	}()
+	stdout := &streamWriter{capture: capture, stream: StreamStdout}
+	stderr := &streamWriter{capture: capture, stream: StreamStderr}
+
+	// The job name becomes $0 and any on-demand arguments $1, $2, ...
+	cmd := exec.Command("/bin/sh", append([]string{"-c", task.Command, task.JobName}, j.args...)...)
+	cmd.Dir = task.WorkingDir
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.WaitDelay = outputWaitDelay
+	setProcessGroup(cmd)
 
-	cmdStartErr := cmd.Start()
+	runAs, cmdStartErr := setCredential(cmd, task.RunAsUser, task.RunAsGroup)
+	cmd.Env = commandEnv(os.Environ(), runAs, task.Env, j.env)
+	if cmdStartErr == nil && cmd.Dir != "" {
+		// exec reports a missing directory as a missing /bin/sh, so check it first
+		if _, err := os.Stat(cmd.Dir); err != nil {
+			cmdStartErr = fmt.Errorf("working directory: %w", err)
+		}
+	}
+	var cgroup *taskCgroup
+	if cmdStartErr == nil && !task.Limits.IsZero() {
+		// The limits are not optional, so the command does not run without them
+		name := fmt.Sprintf("task-%d-run-%s-attempt-%d", task.ID, j.logRun, attempt)
+		if cgroup, cmdStartErr = newTaskCgroup(cgroupParent, name, task.Limits); cmdStartErr == nil {
+			defer cgroup.remove()
+			cgroup.apply(cmd)
+		} else {
+			cmdStartErr = fmt.Errorf("applying resource limits: %w", cmdStartErr)
+		}
+	}
+	if cmdStartErr == nil {
+		cmdStartErr = cmd.Start()
+	}
 	if cmdStartErr != nil {
 		log.Printf("Failed to start task: %s, error: %s", task.JobName, cmdStartErr.Error())
-		return string(output), inExecutionMetrics, cmdStartErr
+		result.Err = cmdStartErr
+		return result
 	}
 	taskPID := cmd.Process.Pid
 	log.Printf("scheduler.utils.executeCommand: Task %s started with PID: %d", task.JobName, taskPID)
 
-	// Periodically collect per-process metrics while the task is running
-	done := make(chan bool)
+	// Terminate the process group if the run is cancelled or times out before it exits
+	exited := make(chan struct{})
 	go func() {
-		for {
-			select {
-			case <-done:
-				return
-			default:
-				metric := monitor.CollectProcessMetrics(int32(taskPID))
-				inExecutionMetrics = append(inExecutionMetrics, metric)
-				time.Sleep(1 * time.Second) // Collect metrics every second
-			}
+		select {
+		case <-ctx.Done():
+			log.Printf("scheduler.utils.executeCommand: Stopping task %s [PID:%d]: %v", task.JobName, taskPID, context.Cause(ctx))
+			stopKill := killProcessGroup(taskPID)
+			<-exited
+			stopKill()
+		case <-exited:
 		}
 	}()
 
-	// Wait for the task to complete and stop collecting metrics
+	// Sample the task's process tree while it runs, through its cgroup's process list if it has one
+	collect := func() (monitor.ProcessMetrics, error) { return monitor.CollectProcessMetrics(int32(taskPID)) }
+	if cgroup != nil {
+		collect = cgroup.processMetrics
+	}
+	sampler := startProcessSampler(taskPID, collect, j.sampling)
+
+	// Wait for the task to complete and its output to be copied, then stop sampling
 	err := cmd.Wait()
+	if errors.Is(err, exec.ErrWaitDelay) {
+		// The command succeeded but left something holding its output open
+		log.Printf("scheduler.utils.executeCommand: Task %s left output open after exiting", task.JobName)
+		err = nil
+	}
 	if err != nil {
 		log.Printf("scheduler.utils.executeCommand: Task %s exited with error: %s", task.JobName, err.Error())
 	}
-	close(done) // Stop the goroutine collecting metrics
-	// Capture the output
-	output, outputErr = cmd.CombinedOutput()
-	log.Printf("scheduler.utils.executeCommand: Task output [PID:%d][%s]:\n\n\n%s", taskPID, task.JobName, string(output))
+	close(exited)
+	samples := sampler.Stop()
+	stdout.flush()
+	stderr.flush()
+
+	exitCode := cmd.ProcessState.ExitCode()
+	result.ExitCode = &exitCode
+	if status, ok := cmd.ProcessState.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		result.Signal = status.Signal().String()
+	}
+	if cgroup != nil {
+		if result.OOMKills = cgroup.oomKills(); result.OOMKills > 0 {
+			log.Printf("scheduler.utils.executeCommand: Task %s exceeded its memory limit: %d process(es) OOM-killed", task.JobName, result.OOMKills)
+		}
+	}
+	result.Usage = commandUsage(cmd.ProcessState, samples, cgroup)
+	result.Metrics = samples
+	result.Err = err
+
+	// The output itself can be hundreds of MB and is in the task's log
+	size, _, _ := capture.state()
+	log.Printf("scheduler.utils.executeCommand: Task %s [PID:%d] wrote %d bytes of output. %s", task.JobName, taskPID, size, result.exitSummary())
+
+	return result
+}
 
-	return string(output), inExecutionMetrics, outputErr
+// passedEnv are the variables of the server's environment that commands inherit. Nothing else is
+// passed on, since the server's environment may hold credentials such as AWS keys or gronicle's
+// own GRONICLE_* configuration. Tasks set anything more they need in their env.
+var passedEnv = []string{"PATH", "LANG", "TZ", "TMPDIR", "HOME", "USER", "LOGNAME"}
+
+// passedEnvPrefixes are prefixes of the names of further variables commands inherit, for locale settings.
+var passedEnvPrefixes = []string{"LC_"}
+
+// commandEnv builds a command's environment from the variables in passedEnv and passedEnvPrefixes
+// of the server's. When the command runs as another user, HOME, USER and LOGNAME are that user's.
+// The task's variables are added next and the run's overrides last.
+func commandEnv(serverEnv []string, runAs *user.User, taskEnv, runEnv map[string]string) []string {
+	env := make([]string, 0, len(passedEnv))
+	for _, kv := range serverEnv {
+		name, _, _ := strings.Cut(kv, "=")
+		if isPassedEnv(name) {
+			env = append(env, kv)
+		}
+	}
+
+	if runAs != nil {
+		env = mergeEnv(env, map[string]string{"HOME": runAs.HomeDir, "USER": runAs.Username, "LOGNAME": runAs.Username})
+	}
+	return mergeEnv(mergeEnv(env, taskEnv), runEnv)
+}
+
+// isPassedEnv reports whether commands inherit the server's variable name.
+func isPassedEnv(name string) bool {
+	for _, passed := range passedEnv {
+		if name == passed {
+			return true
+		}
+	}
+	for _, prefix := range passedEnvPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeEnv returns base with the variables in overrides added or replaced. Overrides are
+// appended in sorted order so the resulting environment is deterministic.
+func mergeEnv(base []string, overrides map[string]string) []string {
+	env := make([]string, 0, len(base)+len(overrides))
+	for _, kv := range base {
+		name, _, _ := strings.Cut(kv, "=")
+		if _, ok := overrides[name]; !ok {
+			env = append(env, kv)
+		}
+	}
+
+	names := make([]string, 0, len(overrides))
+	for name := range overrides {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		env = append(env, name+"="+overrides[name])
+	}
+	return env
 }
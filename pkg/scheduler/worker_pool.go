@@ -1,41 +1,159 @@
 package scheduler
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"github.com/shammishailaj/gronicle/pkg/monitor"
 	"log"
+	"os"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/shammishailaj/gronicle/pkg/storage"
 )
 
+var (
+	// errInterrupted is the cancellation cause for runs killed because gronicle is shutting down.
+	errInterrupted = errors.New("gronicle is shutting down")
+	// errCancelled is the cancellation cause for runs stopped through the API.
+	errCancelled = errors.New("run cancelled by request")
+)
+
+// job is a queued execution of a task.
+type job struct {
+	task  *storage.Task
+	runID int64             // Run recorded when an on-demand run was requested; 0 for scheduled runs
+	args  []string          // Positional parameters passed to the command as $1, $2, ...
+	env   map[string]string // Environment overrides for this run only
+	// secretEnv names variables whose values are masked in this run's output, on top of the task's
+	secretEnv []string
+	// logRun names the run in its log keys: the run ID, or a time-based name if the run could not be recorded
+	logRun string
+	// redactor masks secrets in the run's output
+	redactor *Redactor
+	// sampling is how the run's processes are sampled, with the task's own interval applied
+	sampling SamplerOptions
+}
+
+// activeRun is a run currently being executed by a worker. A run stays tracked until its final
+// status is recorded, so that it can still be followed while its log is being stored.
+type activeRun struct {
+	taskID   int
+	cancel   context.CancelCauseFunc
+	output   *LiveOutput
+	finished bool // The command has finished and the run can no longer be cancelled
+}
+
 // WorkerPool manages a set of workers to execute tasks concurrently.
 type WorkerPool struct {
-	taskQueue   chan *storage.Task
+	taskQueue   chan *job
 	workerCount int
+	busy        atomic.Int32 // Workers processing a job
 	wg          sync.WaitGroup
 	retryLimit  int
-	s3Logger    *storage.S3Logger
+	logSink     storage.LogSink
+	redactor    *Redactor // Global redaction rules, extended per run by jobRedactor
+	// cgroupParent is the cgroup v2 directory under which attempts of tasks with resource limits get their own cgroup
+	cgroupParent string
+	metrics      *storage.MetricsWriter // Stores system snapshots and process samples without blocking runs
+	sampling     SamplerOptions
+	hostname     string
+	ctx          context.Context         // Parent of every run's context
+	cancel       context.CancelCauseFunc // Kills in-flight runs once the drain timeout expires
+	draining     chan struct{}           // Closed by Stop so queued tasks are not started
+	queueMu      sync.RWMutex            // Held for reading by RunNow and for writing by Stop, so no run is queued once the queue is closed
+	activeMu     sync.Mutex
+	active       map[int64]*activeRun // In-flight runs by run ID
 }
 
-// NewWorkerPool initializes a new worker pool.
-func NewWorkerPool(workerCount int, retryLimit int, s3Logger *storage.S3Logger) *WorkerPool {
+// NewWorkerPool initializes a new worker pool that stores task logs in logSink, masking the
+// secrets redactor finds in task output. A nil logSink stores them on local disk in
+// storage.DefaultLocalLogDir, and a nil redactor only applies each task's own rules. Each
+// attempt of a task with resource limits runs in its own cgroup under cgroupParent. Metrics are
+// stored through metrics, which the caller starts and stops; a nil metrics discards them. While
+// an attempt runs its process tree is sampled as sampling says, unless the task sets its own interval.
+func NewWorkerPool(workerCount int, retryLimit int, logSink storage.LogSink, redactor *Redactor, cgroupParent string, metrics *storage.MetricsWriter, sampling SamplerOptions) *WorkerPool {
+	if logSink == nil {
+		logSink = storage.NewLocalLogSink(storage.DefaultLocalLogDir)
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		log.Printf("Failed to determine hostname: %v", err)
+		hostname = "unknown"
+	}
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+
 	return &WorkerPool{
-		taskQueue:   make(chan *storage.Task, 100),
-		workerCount: workerCount,
-		retryLimit:  retryLimit,
-		s3Logger:    s3Logger,
+		taskQueue:    make(chan *job, 100),
+		workerCount:  workerCount,
+		retryLimit:   retryLimit,
+		logSink:      logSink,
+		redactor:     redactor,
+		cgroupParent: cgroupParent,
+		metrics:      metrics,
+		sampling:     sampling,
+		hostname:     hostname,
+		ctx:          ctx,
+		cancel:       cancel,
+		draining:     make(chan struct{}),
+		active:       make(map[int64]*activeRun),
 	}
 }
 
 // AddTask adds a task to the queue.
 func (wp *WorkerPool) AddTask(task *storage.Task) {
-	wp.taskQueue <- task
+	wp.taskQueue <- &job{task: task}
 	log.Printf("Task added to queue: %s", task.JobName)
 }
 
+// RunNow queues an extra on-demand run of a task, outside of its schedule, and returns the
+// new run's ID. args are passed to the command as positional parameters and env overrides
+// environment variables for this run only; the values of those named in secretEnv are masked
+// like the task's secret ones. It fails rather than blocks if the queue is full.
+func (wp *WorkerPool) RunNow(db *sql.DB, task *storage.Task, args []string, env map[string]string, secretEnv []string) (int64, error) {
+	wp.queueMu.RLock()
+	defer wp.queueMu.RUnlock()
+
+	if wp.isDraining() {
+		return 0, errors.New("worker pool is shutting down")
+	}
+	if wp.FreeSlots() == 0 {
+		return 0, errors.New("task queue is full")
+	}
+
+	runID, err := storage.InsertQueuedTaskRun(db, task.ID, "manual")
+	if err != nil {
+		return 0, err
+	}
+
+	select {
+	case wp.taskQueue <- &job{task: task, runID: runID, args: args, env: env, secretEnv: secretEnv}:
+		log.Printf("On-demand run %d of task %s added to queue", runID, task.JobName)
+		return runID, nil
+	default:
+		storage.FinishTaskRun(db, runID, "failed", time.Now(), "")
+		return 0, errors.New("task queue is full")
+	}
+}
+
+// FreeSlots returns how many more tasks the queue can take without blocking.
+func (wp *WorkerPool) FreeSlots() int {
+	return cap(wp.taskQueue) - len(wp.taskQueue)
+}
+
+// IdleWorkers returns how many workers would pick up a task right away: those not processing a
+// job, less the jobs already waiting for them.
+func (wp *WorkerPool) IdleWorkers() int {
+	return max(wp.workerCount-int(wp.busy.Load())-len(wp.taskQueue), 0)
+}
+
 // Start initializes the workers and begins processing tasks.
 func (wp *WorkerPool) Start(db *sql.DB) {
 	log.Printf("Starting %d workers...", wp.workerCount)
@@ -46,53 +164,145 @@ func (wp *WorkerPool) Start(db *sql.DB) {
 		go func(workerID int) {
 			defer wp.wg.Done()
 
-			for task := range wp.taskQueue {
-				log.Printf("Worker %d executing task: %s", workerID, task.JobName)
-				success, output := wp.executeTaskWithRetry(db, task)
-
-				if success {
-					log.Printf("Task completed successfully: %s", task.JobName)
-					wp.uploadLogToS3(task.JobName, output)
-				} else {
-					log.Printf("Task failed after retries: %s", task.JobName)
-					wp.uploadLogToS3(task.JobName, fmt.Sprintf("Task failed: %s", output))
-				}
+			for j := range wp.taskQueue {
+				wp.busy.Add(1)
+				wp.processJob(db, workerID, j)
+				wp.busy.Add(-1)
 			}
 		}(i)
 	}
 }
 
+// processJob executes one queued job on a worker and records its run.
+func (wp *WorkerPool) processJob(db *sql.DB, workerID int, j *job) {
+	task := j.task
+
+	if wp.isDraining() {
+		log.Printf("Worker %d not starting task %s: shutting down", workerID, task.JobName)
+		wp.abandonJob(db, j)
+		return
+	}
+
+	// An on-demand run cancelled while it was queued is not started
+	if j.runID != 0 {
+		if started, err := storage.StartTaskRun(db, j.runID, workerID, wp.hostname, time.Now()); err == nil && !started {
+			log.Printf("Worker %d skipping run %d of task %s: no longer queued", workerID, j.runID, task.JobName)
+			return
+		}
+	}
+
+	release := func() {}
+	if task.LeaseOwner != "" {
+		var ok bool
+		if release, ok = holdLease(db, task); !ok {
+			log.Printf("Worker %d skipping task %s: lease no longer held", workerID, task.JobName)
+			return
+		}
+	}
+
+	runID := j.runID
+	if runID == 0 {
+		var err error
+		if runID, err = storage.InsertTaskRun(db, task.ID, workerID, wp.hostname, time.Now()); err != nil {
+			log.Printf("Worker %d could not record run for task %s: %v", workerID, task.JobName, err)
+		}
+	}
+
+	j.logRun = runLogName(runID)
+	j.redactor = jobRedactor(wp.redactor, j)
+	j.sampling = wp.sampling.forTask(task.SampleInterval)
+
+	log.Printf("Worker %d executing task: %s (run %d)", workerID, task.JobName, runID)
+	runCtx, cancelRun := context.WithCancelCause(wp.ctx)
+	live := wp.trackRun(runID, task.ID, cancelRun)
+	status, logKey := wp.executeTaskWithRetry(runCtx, db, j, runID, live)
+	live.finish(status)
+	wp.finishRun(runID)
+	cancelRun(nil)
+	release()
+
+	switch status {
+	case "completed":
+		log.Printf("Task completed successfully: %s", task.JobName)
+	case "interrupted":
+		log.Printf("Task interrupted by shutdown: %s", task.JobName)
+	case "cancelled":
+		log.Printf("Task cancelled: %s", task.JobName)
+	case "timed_out":
+		log.Printf("Task timed out: %s", task.JobName)
+	default:
+		log.Printf("Task failed after retries: %s", task.JobName)
+	}
+
+	if runID != 0 {
+		storage.FinishTaskRun(db, runID, status, time.Now(), logKey)
+	}
+	// Only untracked once its status is recorded, so a follower never finds the run recorded as
+	// running on this host without it being tracked
+	wp.untrackRun(runID)
+	live.Release()
+}
+
+// abandonJob gives up on a job that was queued but will not be started because of shutdown.
+// A claimed task is handed back so its run happens after the restart instead of being lost,
+// and an on-demand run is recorded as interrupted.
+func (wp *WorkerPool) abandonJob(db *sql.DB, j *job) {
+	if j.task.LeaseOwner != "" {
+		if err := storage.UnclaimTask(db, j.task); err != nil {
+			log.Printf("Failed to unclaim task %d: %v", j.task.ID, err)
+		}
+	}
+	if j.runID != 0 {
+		storage.FinishTaskRun(db, j.runID, "interrupted", time.Now(), "")
+	}
+}
+
 // executeTaskWithRetry tries to execute a task and retries if it fails and logs its execution duration.
-func (wp *WorkerPool) executeTaskWithRetry(db *sql.DB, task *storage.Task) (bool, string) {
+// Every attempt is recorded against runID in the run history, and its output is stored as its own
+// log. It returns the run's final status, "completed", "failed", "timed_out", or "cancelled" or
+// "interrupted" when ctx is cancelled, along with the key of the last attempt's log, "" if it
+// could not be stored. Cancellation also stops any remaining retries.
+func (wp *WorkerPool) executeTaskWithRetry(ctx context.Context, db *sql.DB, j *job, runID int64, live *LiveOutput) (string, string) {
+	task := j.task
 	startTime := time.Now() // Track start time
 
 	// Collect pre-execution system metrics
 	preMetrics := monitor.CollectMetrics()
 	log.Printf("Pre-execution metrics for task %d: %+v", task.ID, preMetrics)
-	insertTaskMetricsErr := storage.InsertTaskMetrics(db, task.ID, preMetrics)
-	if insertTaskMetricsErr != nil {
-		log.Printf("scheduler.WorkerPool.executeTaskWithRetry: failed to insert task metrics: %s", insertTaskMetricsErr.Error())
-	}
+	wp.metrics.Write(storage.SystemMetricsRow(task.ID, runID, 0, storage.MetricsPhasePre, preMetrics))
 
 	// Attempt to execute the task and track its process
 	var (
-		output             string
-		inExecutionMetrics []monitor.ProcessMetrics
-		outputErr          error
+		output    *outputCapture
+		result    *commandResult
+		outputErr error
+		logKey    string
 	)
 
 	for attempt := 1; attempt <= wp.retryLimit; attempt++ {
 		log.Printf("Attempt %d to execute task: %s", attempt, task.JobName)
 
-		// Start the task and get its PID
-		output, inExecutionMetrics, outputErr = executeCommand(task)
+		attemptID := wp.startAttempt(db, runID, attempt)
 
-		// Collect system metrics after execution
-		metrics := monitor.CollectMetrics()
-		insertTaskMetricsErr = storage.InsertTaskMetrics(db, task.ID, metrics)
+		// Start the task and get its PID, bounded by the task's timeout if it has one
+		attemptCtx, cancelAttempt := ctx, context.CancelFunc(func() {})
+		if task.TimeoutSeconds > 0 {
+			attemptCtx, cancelAttempt = context.WithTimeoutCause(ctx, time.Duration(task.TimeoutSeconds)*time.Second, errTimedOut)
+		}
+		var err error
+		if output, err = newOutputCapture(j.redactor); err == nil {
+			live.addAttempt(output)
+			result = executeCommand(attemptCtx, j, wp.cgroupParent, attempt, output)
+		} else {
+			result = &commandResult{Err: fmt.Errorf("capturing output: %w", err)}
+		}
+		outputErr = result.Err
+		timedOut := ctx.Err() == nil && errors.Is(context.Cause(attemptCtx), errTimedOut)
+		cancelAttempt()
 
-		if insertTaskMetricsErr != nil {
-			log.Printf("scheduler.WorkerPool.executeTaskWithRetry: failed to insert task metrics: %s", insertTaskMetricsErr.Error())
+		// Store the samples of the attempt's process tree, whether or not it succeeded
+		for _, metric := range result.Metrics {
+			wp.metrics.Write(storage.ProcessMetricsRow(task.ID, runID, attempt, metric))
 		}
 
 		wp.logTaskDuration(db, task.ID, startTime, time.Now(), "completed")
@@ -102,21 +312,12 @@ func (wp *WorkerPool) executeTaskWithRetry(db *sql.DB, task *storage.Task) (bool
 			postMetrics := monitor.CollectMetrics()
 			log.Printf("Post-execution metrics for task %d: %+v", task.ID, postMetrics)
 
-			// Store in-execution and post-execution metrics
-			for _, metric := range inExecutionMetrics {
-				insertProcessMetricsErr := storage.InsertProcessTaskMetrics(db, task.ID, metric)
-				if insertProcessMetricsErr != nil {
-					log.Printf("scheduler.WorkerPool.executeTaskWithRetry: failed to insert process metrics: %s", insertProcessMetricsErr.Error())
-				}
-			}
-
-			insertTaskMetricsErr = storage.InsertTaskMetrics(db, task.ID, postMetrics)
-			if insertTaskMetricsErr != nil {
-				log.Printf("scheduler.WorkerPool.executeTaskWithRetry: failed to insert task metrics: %s", insertTaskMetricsErr.Error())
-			}
+			wp.metrics.Write(storage.SystemMetricsRow(task.ID, runID, attempt, storage.MetricsPhasePost, postMetrics))
 
 			wp.logTaskDuration(db, task.ID, startTime, time.Now(), "completed")
-			return true, string(output) // Task succeeded
+			logKey = wp.uploadLog(j, attempt, output, "")
+			wp.finishAttempt(db, runID, attemptID, "completed", result, logKey)
+			return "completed", logKey // Task succeeded
 		}
 
 		log.Printf("Task failed on attempt %d: %s, error: %s", attempt, task.JobName, outputErr.Error())
@@ -125,36 +326,174 @@ func (wp *WorkerPool) executeTaskWithRetry(db *sql.DB, task *storage.Task) (bool
 		postFailureMetrics := monitor.CollectMetrics()
 		log.Printf("Post-failure metrics for task %d: %+v", task.ID, postFailureMetrics)
 
-		insertTaskMetricsErr = storage.InsertTaskMetrics(db, task.ID, postFailureMetrics)
-		if insertTaskMetricsErr != nil {
-			log.Printf("scheduler.WorkerPool.executeTaskWithRetry: failed to insert task metrics: %s", insertTaskMetricsErr.Error())
+		wp.metrics.Write(storage.SystemMetricsRow(task.ID, runID, attempt, storage.MetricsPhaseFailure, postFailureMetrics))
+
+		if ctx.Err() != nil {
+			status := stoppedStatus(ctx)
+			log.Printf("Task %s on attempt %d: %s, cause: %v", status, attempt, task.JobName, context.Cause(ctx))
+			wp.logTaskDuration(db, task.ID, startTime, time.Now(), status)
+			if status == "cancelled" {
+				logKey = wp.uploadLog(j, attempt, output, "Task cancelled")
+			} else {
+				logKey = wp.uploadLog(j, attempt, output, "Task interrupted")
+			}
+			wp.finishAttempt(db, runID, attemptID, status, result, logKey)
+			return status, logKey
+		}
+
+		// A hung command is likely to hang again, so a timeout is not retried
+		if timedOut {
+			log.Printf("Task timed out after %ds on attempt %d: %s", task.TimeoutSeconds, attempt, task.JobName)
+			wp.logTaskDuration(db, task.ID, startTime, time.Now(), "timed_out")
+			wp.logTaskFailure(j, attempt, errTimedOut.Error())
+			logKey = wp.uploadLog(j, attempt, output, "Task timed out")
+			wp.finishAttempt(db, runID, attemptID, "timed_out", result, logKey)
+			return "timed_out", logKey
 		}
 
 		wp.logTaskDuration(db, task.ID, startTime, time.Now(), "failed")
-		wp.logTaskFailure(task.JobName, attempt, outputErr.Error())
-		time.Sleep(2 * time.Second) // Backoff before retry
+		wp.logTaskFailure(j, attempt, outputErr.Error())
+		logKey = wp.uploadLog(j, attempt, output, "Task failed")
+		wp.finishAttempt(db, runID, attemptID, "failed", result, logKey)
+
+		// Backoff before retry, giving up on the remaining attempts if cancelled or interrupted
+		select {
+		case <-ctx.Done():
+			status := stoppedStatus(ctx)
+			log.Printf("Task %s before retrying: %s, cause: %v", status, task.JobName, context.Cause(ctx))
+			wp.logTaskDuration(db, task.ID, startTime, time.Now(), status)
+			return status, logKey
+		case <-time.After(2 * time.Second):
+		}
 	}
 
-	// Collect post-execution metrics after failure
-	postFailureMetrics := monitor.CollectMetrics()
-	log.Printf("Post-failure metrics for task %d: %+v", task.ID, postFailureMetrics)
-	insertTaskMetricsErr = storage.InsertTaskMetrics(db, task.ID, postFailureMetrics)
-	if insertTaskMetricsErr != nil {
-		log.Printf("scheduler.WorkerPool.executeTaskWithRetry: failed to insert task metrics: %s", insertTaskMetricsErr.Error())
+	// The last attempt's failure snapshot is already stored
+	wp.logTaskDuration(db, task.ID, startTime, time.Now(), "failed")
+
+	return "failed", logKey // Task failed after retries
+}
+
+// stoppedStatus returns the run status for a run whose context was cancelled.
+func stoppedStatus(ctx context.Context) string {
+	if errors.Is(context.Cause(ctx), errCancelled) {
+		return "cancelled"
 	}
+	return "interrupted"
+}
 
-	wp.logTaskDuration(db, task.ID, startTime, time.Now(), "failed")
+// trackRun registers an in-flight run so it can be cancelled and followed, and returns
+// the LiveOutput its attempts should be captured into.
+func (wp *WorkerPool) trackRun(runID int64, taskID int, cancel context.CancelCauseFunc) *LiveOutput {
+	wp.activeMu.Lock()
+	defer wp.activeMu.Unlock()
+
+	output := newLiveOutput()
+	wp.active[runID] = &activeRun{taskID: taskID, cancel: cancel, output: output}
+	return output
+}
+
+// finishRun marks a run registered by trackRun as finished, so it is no longer cancelled.
+func (wp *WorkerPool) finishRun(runID int64) {
+	wp.activeMu.Lock()
+	defer wp.activeMu.Unlock()
+	if run, ok := wp.active[runID]; ok {
+		run.finished = true
+	}
+}
+
+// untrackRun removes a run registered by trackRun.
+func (wp *WorkerPool) untrackRun(runID int64) {
+	wp.activeMu.Lock()
+	defer wp.activeMu.Unlock()
+	delete(wp.active, runID)
+}
+
+// LiveOutput returns the output of a run in flight on this worker pool, or nil if it is not
+// executing here. The caller must Release it once it is done following it.
+func (wp *WorkerPool) LiveOutput(runID int64) *LiveOutput {
+	wp.activeMu.Lock()
+	defer wp.activeMu.Unlock()
+
+	if run, ok := wp.active[runID]; ok {
+		run.output.acquire()
+		return run.output
+	}
+	return nil
+}
+
+// MetricsDropped returns how many metrics rows this worker pool produced that were never stored.
+func (wp *WorkerPool) MetricsDropped() int64 {
+	return wp.metrics.Dropped()
+}
+
+// Hostname returns the host name recorded on runs executed by this worker pool.
+func (wp *WorkerPool) Hostname() string {
+	return wp.hostname
+}
+
+// CancelRun stops an in-flight run on this worker pool: its process group is terminated and
+// its remaining retries are skipped. It reports false if the run is not executing here.
+func (wp *WorkerPool) CancelRun(runID int64) bool {
+	wp.activeMu.Lock()
+	defer wp.activeMu.Unlock()
+
+	run, ok := wp.active[runID]
+	if !ok || run.finished {
+		return false
+	}
+	run.cancel(errCancelled)
+	return true
+}
 
-	return false, output // Task failed after retries
+// CancelTask stops every in-flight run of a task on this worker pool and returns how many were cancelled.
+func (wp *WorkerPool) CancelTask(taskID int) int {
+	wp.activeMu.Lock()
+	defer wp.activeMu.Unlock()
+
+	cancelled := 0
+	for _, run := range wp.active {
+		if run.taskID == taskID && !run.finished {
+			run.cancel(errCancelled)
+			cancelled++
+		}
+	}
+	return cancelled
 }
 
-// logTaskFailure logs task failures with retry details and error messages.
-func (wp *WorkerPool) logTaskFailure(taskName string, attempt int, errorMsg string) {
-	logContent := fmt.Sprintf("Task: %s\nAttempt: %d\nError: %s\nTimestamp: %s\n\n",
-		taskName, attempt, errorMsg, time.Now().Format(time.RFC3339))
+// startAttempt records the start of an attempt and returns its ID, or 0 if it could not be recorded.
+func (wp *WorkerPool) startAttempt(db *sql.DB, runID int64, attempt int) int64 {
+	if runID == 0 {
+		return 0
+	}
+
+	attemptID, err := storage.InsertTaskRunAttempt(db, runID, attempt, time.Now())
+	if err != nil {
+		return 0
+	}
+	return attemptID
+}
 
-	filename := fmt.Sprintf("failed_tasks/%s_%d.log", taskName, attempt)
-	wp.s3Logger.UploadLog(filename, logContent)
+// finishAttempt records the outcome of an attempt started by startAttempt and adds what its
+// processes consumed to the run's totals.
+func (wp *WorkerPool) finishAttempt(db *sql.DB, runID, attemptID int64, status string, result *commandResult, logKey string) {
+	if runID != 0 && result.ExitCode != nil {
+		storage.AddTaskRunUsage(db, runID, result.Usage)
+	}
+	if attemptID == 0 {
+		return
+	}
+	storage.FinishTaskRunAttempt(db, attemptID, status, result.ExitCode, result.Signal, result.OOMKills, time.Now(), logKey)
+}
+
+// logTaskFailure stores a summary of a failed attempt with its error message.
+func (wp *WorkerPool) logTaskFailure(j *job, attempt int, errorMsg string) {
+	logContent := fmt.Sprintf("Task: %s\nRun: %s\nAttempt: %d\nError: %s\nTimestamp: %s\n\n",
+		j.task.JobName, j.logRun, attempt, j.redactor.Redact(errorMsg), time.Now().Format(time.RFC3339))
+
+	filename := storage.FailedAttemptLogKey(j.task.ID, j.logRun, attempt)
+	if err := wp.logSink.UploadLog(filename, strings.NewReader(logContent)); err != nil {
+		log.Printf("Failed to store failure log %s: %v", filename, err)
+	}
 }
 
 // logTaskDuration updates the task's execution time and status in the database.
@@ -165,17 +504,67 @@ func (wp *WorkerPool) logTaskDuration(db *sql.DB, taskID int, startTime, endTime
 	}
 }
 
-// uploadLogToS3 uploads the task output to S3.
-func (wp *WorkerPool) uploadLogToS3(taskName string, output string) {
-	timestamp := time.Now().Format("2006-01-02_15-04-05")
-	filename := fmt.Sprintf("logs/%s/%s.log", taskName, timestamp)
+// uploadLog stores the output of one of the run's attempts as its log, ending with note if it is
+// not empty, and returns the log's key, or "" if there is no output or it could not be stored.
+func (wp *WorkerPool) uploadLog(j *job, attempt int, output *outputCapture, note string) string {
+	if output == nil {
+		return ""
+	}
+	if note != "" {
+		output.addNote(note)
+	}
+	filename := storage.RunLogKey(j.task.ID, j.logRun, attempt)
 
-	wp.s3Logger.UploadLog(filename, output)
+	if err := wp.logSink.UploadLog(filename, output.content()); err != nil {
+		log.Printf("Failed to store log %s: %v", filename, err)
+		return ""
+	}
+	return filename
 }
 
-// Stop closes the task queue and waits for all workers to complete.
-func (wp *WorkerPool) Stop() {
+// runLogName names a run's logs after its ID. A run that could not be recorded has no ID, so its
+// logs are named after the current time instead, precise enough not to collide with another run.
+func runLogName(runID int64) string {
+	if runID == 0 {
+		return storage.UnrecordedRunName(time.Now())
+	}
+	return strconv.FormatInt(runID, 10)
+}
+
+// isDraining reports whether Stop has been called.
+func (wp *WorkerPool) isDraining() bool {
+	select {
+	case <-wp.draining:
+		return true
+	default:
+		return false
+	}
+}
+
+// Stop closes the task queue and waits up to drainTimeout for in-flight runs to finish.
+// Queued tasks that have not started are handed back to the database. Runs still going
+// when the timeout expires are killed and recorded as interrupted. No task may be added
+// once Stop has been called.
+func (wp *WorkerPool) Stop(drainTimeout time.Duration) {
+	wp.queueMu.Lock()
+	close(wp.draining)
 	close(wp.taskQueue)
-	wp.wg.Wait()
+	wp.queueMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		wp.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(drainTimeout):
+		log.Printf("Runs still in progress after %s, interrupting them...", drainTimeout)
+		wp.cancel(errInterrupted)
+		<-done
+	}
+	wp.cancel(nil)
+
 	log.Println("All workers have completed their tasks.")
 }
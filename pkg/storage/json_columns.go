@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// StringList is a list of strings stored in a JSON column. An empty list is stored as NULL.
+type StringList []string
+
+// Value implements driver.Valuer.
+func (l StringList) Value() (driver.Value, error) {
+	if len(l) == 0 {
+		return nil, nil
+	}
+	return json.Marshal([]string(l))
+}
+
+// Scan implements sql.Scanner.
+func (l *StringList) Scan(src interface{}) error {
+	*l = nil
+	return scanJSON(src, (*[]string)(l))
+}
+
+// scanJSON decodes a JSON column into dst, leaving dst untouched for NULL.
+func scanJSON(src interface{}, dst interface{}) error {
+	switch value := src.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return json.Unmarshal(value, dst)
+	case string:
+		return json.Unmarshal([]byte(value), dst)
+	default:
+		return fmt.Errorf("cannot scan %T into a JSON column", src)
+	}
+}
+
+// StringMap is a string-to-string map stored in a JSON column. An empty map is stored as NULL.
+type StringMap map[string]string
+
+// Value implements driver.Valuer.
+func (m StringMap) Value() (driver.Value, error) {
+	if len(m) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(map[string]string(m))
+}
+
+// Scan implements sql.Scanner.
+func (m *StringMap) Scan(src interface{}) error {
+	*m = nil
+	return scanJSON(src, (*map[string]string)(m))
+}
@@ -0,0 +1,284 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Logs are stored under keys built from IDs rather than job names, so renaming a task or reusing
+// its name never mixes histories:
+//
+//	logs/<task_id>/<run_id>/<attempt>.log          output of a run, stored for its last attempt
+//	failed_tasks/<task_id>/<run_id>/<attempt>.log  error summary of a failed attempt
+//
+// A run that could not be recorded in the database has no ID; its logs use a time-based run name
+// starting with UnrecordedRunPrefix instead.
+//
+// MigrateLogKeys moves logs from the layouts used before:
+//
+//	logs/<job_name>/<run_id or timestamp>.log
+//	failed_tasks/<job_name>_<attempt>.log             overwritten by every run of the task
+//	failed_tasks/<job_name>/<run_name>_<attempt>.log
+
+// UnrecordedRunPrefix starts the run name of logs whose run has no ID.
+const UnrecordedRunPrefix = "unrecorded-"
+
+// legacyLogTimestamp is the format of the timestamp in log keys written before logs were keyed by ID.
+const legacyLogTimestamp = "2006-01-02_15-04-05"
+
+// legacyRunWindow bounds how long after a legacy log's timestamp its run may have ended. The log was
+// uploaded just before the run was finished, but uploads retry with backoff for a while.
+const legacyRunWindow = time.Minute
+
+// TaskLogPrefix returns the prefix of every run log of a task.
+func TaskLogPrefix(taskID int) string {
+	return fmt.Sprintf("logs/%d/", taskID)
+}
+
+// RunLogKey returns the key of the output log of a run's attempt.
+func RunLogKey(taskID int, run string, attempt int) string {
+	return fmt.Sprintf("%s%s/%d.log", TaskLogPrefix(taskID), run, attempt)
+}
+
+// FailedAttemptLogKey returns the key of the error summary of a failed attempt.
+func FailedAttemptLogKey(taskID int, run string, attempt int) string {
+	return fmt.Sprintf("failed_tasks/%d/%s/%d.log", taskID, run, attempt)
+}
+
+// UnrecordedRunName names the logs of a run that has no ID after the time it ran.
+func UnrecordedRunName(t time.Time) string {
+	return UnrecordedRunPrefix + t.UTC().Format("20060102T150405.000000000")
+}
+
+// LogKeyMove is a log moved, or to be moved, from a job-name key to a task ID and run ID key.
+type LogKeyMove struct {
+	From string
+	To   string
+}
+
+// LogKeyMigration reports what MigrateLogKeys did.
+type LogKeyMigration struct {
+	Moved   []LogKeyMove
+	Skipped map[string]string // Legacy key to the reason it was not moved
+}
+
+// MigrateLogKeys moves logs stored under job names to keys built from task and run IDs and points
+// the runs and attempts that referenced them at the new keys. Run logs move from
+// logs/<job_name>/<name>.log to logs/<task_id>/<run_id>/<attempt>.log, with the run taken from the
+// name when it is a run ID, or otherwise matched by the time the timestamp in the name says the log
+// was written. Failed attempt summaries move from failed_tasks/<job_name>/<run_name>_<attempt>.log
+// to failed_tasks/<task_id>/<run_name>/<attempt>.log. The oldest ones, failed_tasks/<job_name>_<attempt>.log,
+// only hold the last run to fail and name no run, so they are named after when they were written.
+// The job name is resolved to the task that has it; logs whose job name matches no task, or more
+// than one, are skipped. With dryRun set nothing is changed. Keys already in the new layout are left
+// alone, so it is safe to run more than once.
+func MigrateLogKeys(db *sql.DB, logSink LogSink, dryRun bool) (*LogKeyMigration, error) {
+	keys, err := logSink.ListLogFiles("logs/")
+	if err != nil {
+		return nil, err
+	}
+	failedLogs, err := logSink.ListLogObjects("failed_tasks/", "", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	tasks, err := FetchAllTasks(db)
+	if err != nil {
+		return nil, err
+	}
+	taskIDs := make(map[string][]int)
+	for _, task := range tasks {
+		taskIDs[task.JobName] = append(taskIDs[task.JobName], task.ID)
+		// Keys written since job names were sanitized use the sanitized name
+		if sanitized := SanitizeKeySegment(task.JobName); sanitized != task.JobName {
+			taskIDs[sanitized] = append(taskIDs[sanitized], task.ID)
+		}
+	}
+
+	migration := &LogKeyMigration{Skipped: make(map[string]string)}
+
+	// taskFor resolves a job name to its task, recording why key is skipped if it cannot
+	taskFor := func(key, jobName string) (int, bool) {
+		ids := taskIDs[jobName]
+		switch len(ids) {
+		case 1:
+			return ids[0], true
+		case 0:
+			migration.Skipped[key] = fmt.Sprintf("no task is named %q", jobName)
+		default:
+			migration.Skipped[key] = fmt.Sprintf("%d tasks are named %q", len(ids), jobName)
+		}
+		return 0, false
+	}
+
+	apply := func(move LogKeyMove) error {
+		if !dryRun {
+			if err := moveLog(db, logSink, move); err != nil {
+				return fmt.Errorf("moving %s to %s: %w", move.From, move.To, err)
+			}
+			log.Printf("Moved log %s to %s", move.From, move.To)
+		}
+		migration.Moved = append(migration.Moved, move)
+		return nil
+	}
+
+	for _, key := range keys {
+		segments := strings.Split(strings.TrimPrefix(key, "logs/"), "/")
+		if len(segments) != 2 || !strings.HasSuffix(segments[1], ".log") {
+			continue // Already in the new layout
+		}
+		jobName, name := segments[0], strings.TrimSuffix(segments[1], ".log")
+
+		taskID, ok := taskFor(key, jobName)
+		if !ok {
+			continue
+		}
+
+		newKey, err := legacyLogDestination(db, taskID, name)
+		if err != nil {
+			return migration, fmt.Errorf("resolving run of %s: %w", key, err)
+		}
+		if err := apply(LogKeyMove{From: key, To: newKey}); err != nil {
+			return migration, err
+		}
+	}
+
+	for _, object := range failedLogs {
+		segments := strings.Split(strings.TrimPrefix(object.Key, "failed_tasks/"), "/")
+		last := segments[len(segments)-1]
+		if !strings.HasSuffix(last, ".log") {
+			continue
+		}
+		base := strings.TrimSuffix(last, ".log")
+		if len(segments) == 3 && isDigits(base) {
+			continue // Already in the new layout
+		}
+
+		cut := strings.LastIndex(base, "_")
+		if cut < 0 || !isDigits(base[cut+1:]) {
+			migration.Skipped[object.Key] = "not named after an attempt"
+			continue
+		}
+		name, attempt := base[:cut], base[cut+1:]
+
+		var jobName, run string
+		if len(segments) == 2 && isLegacyRunName(name) {
+			jobName, run = segments[0], name
+		} else {
+			// Job names were not sanitized then, so a slash in one nests the key
+			jobName = strings.Join(append(segments[:len(segments)-1:len(segments)-1], name), "/")
+			run = UnrecordedRunName(object.LastModified)
+		}
+
+		taskID, ok := taskFor(object.Key, jobName)
+		if !ok {
+			continue
+		}
+		attemptNumber, _ := strconv.Atoi(attempt)
+		if err := apply(LogKeyMove{From: object.Key, To: FailedAttemptLogKey(taskID, run, attemptNumber)}); err != nil {
+			return migration, err
+		}
+	}
+	return migration, nil
+}
+
+// isDigits reports whether s is a non-empty string of ASCII digits.
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// isLegacyRunName reports whether name is a run name as failed attempt logs were keyed by before
+// the new layout: a run ID, or the time-based name of a run that could not be recorded.
+func isLegacyRunName(name string) bool {
+	return isDigits(name) || strings.HasPrefix(name, UnrecordedRunPrefix)
+}
+
+// legacyLogDestination returns the new key of a legacy log of taskID named name, which is either
+// a run ID or the time the log was written.
+func legacyLogDestination(db *sql.DB, taskID int, name string) (string, error) {
+	var runID int64
+	var writtenAt time.Time
+	if id, err := strconv.ParseInt(name, 10, 64); err == nil {
+		runID = id
+	} else if t, err := time.ParseInLocation(legacyLogTimestamp, name, time.Local); err == nil {
+		writtenAt = t
+		if runID, err = FetchRunEndedAfter(db, taskID, t, legacyRunWindow); err != nil {
+			return "", err
+		}
+	} else {
+		// Neither a run ID nor a timestamp; keep the name so that nothing collides
+		return RunLogKey(taskID, UnrecordedRunPrefix+SanitizeKeySegment(name), 1), nil
+	}
+
+	if runID == 0 {
+		// Written before runs were recorded
+		return RunLogKey(taskID, UnrecordedRunName(writtenAt), 1), nil
+	}
+
+	attempt, err := FetchLastAttemptNumber(db, runID)
+	if err != nil {
+		return "", err
+	}
+	if attempt == 0 {
+		attempt = 1
+	}
+	return RunLogKey(taskID, strconv.FormatInt(runID, 10), attempt), nil
+}
+
+// moveLog copies a log to its new key through a temporary file, so that large logs are not held
+// in memory, repoints the runs that referenced it and then deletes the original. The original is
+// only deleted once the copy is confirmed to be stored where the original was.
+func moveLog(db *sql.DB, logSink LogSink, move LogKeyMove) error {
+	body, err := logSink.OpenLog(move.From)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	spool, err := os.CreateTemp("", "gronicle-log-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	size, err := io.Copy(spool, body)
+	if err != nil {
+		return err
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	if s3Logger, ok := logSink.(*S3Logger); ok {
+		// UploadLog falls back to local_logs on this host when S3 fails, which would leave the only
+		// copy here once the original is deleted, so S3 is written to directly and checked instead
+		if err := s3Logger.uploadToS3(move.To, spool); err != nil {
+			return err
+		}
+		if err := s3Logger.checkLogSize(move.To, size); err != nil {
+			return err
+		}
+	} else if err := logSink.UploadLog(move.To, spool); err != nil {
+		return err
+	}
+
+	if err := ReplaceLogKey(db, move.From, move.To); err != nil {
+		return err
+	}
+	return logSink.DeleteLog(move.From)
+}
@@ -0,0 +1,242 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LocalLogBacklog summarizes the logs waiting in LocalFallbackDir to be replayed to S3.
+type LocalLogBacklog struct {
+	Files            int        `json:"files"`
+	Bytes            int64      `json:"bytes"`
+	OldestAt         *time.Time `json:"oldest_at"`
+	OldestAgeSeconds float64    `json:"oldest_age_seconds"`
+}
+
+// fallbackMetaSuffix names the metadata sidecar written next to each fallback log.
+const fallbackMetaSuffix = ".meta.json"
+
+// fallbackMeta is the metadata sidecar of a fallback log.
+type fallbackMeta struct {
+	Key     string    `json:"key"`
+	Bucket  string    `json:"bucket"`
+	SavedAt time.Time `json:"saved_at"`
+}
+
+// fallbackLog is a log file waiting in the fallback directory.
+type fallbackLog struct {
+	path    string
+	key     string
+	size    int64
+	modTime time.Time
+}
+
+// metaPath returns the path of the log's metadata sidecar.
+func (f fallbackLog) metaPath() string {
+	return f.path + fallbackMetaSuffix
+}
+
+// listFallbackLogs returns the log files under dir, oldest first. A missing dir has no logs.
+// Each log's key is read from its metadata sidecar; logs saved before sidecars existed fall
+// back to their path relative to dir.
+func listFallbackLogs(dir string) ([]fallbackLog, error) {
+	var logs []fallbackLog
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		name := entry.Name()
+		if entry.IsDir() || strings.HasSuffix(name, fallbackMetaSuffix) || strings.HasSuffix(name, tempFileSuffix) {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		fallback := fallbackLog{
+			path:    path,
+			key:     filepath.ToSlash(rel),
+			size:    info.Size(),
+			modTime: info.ModTime(),
+		}
+		if meta, err := readFallbackMeta(fallback.metaPath()); err == nil {
+			fallback.key = meta.Key
+			fallback.modTime = meta.SavedAt
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("Ignoring unreadable metadata for local log %s: %v", path, err)
+		}
+
+		logs = append(logs, fallback)
+		return nil
+	})
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	sort.Slice(logs, func(i, j int) bool { return logs[i].modTime.Before(logs[j].modTime) })
+	return logs, nil
+}
+
+// readFallbackMeta reads a fallback log's metadata sidecar.
+func readFallbackMeta(path string) (fallbackMeta, error) {
+	var meta fallbackMeta
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return meta, err
+	}
+	if err := json.Unmarshal(content, &meta); err != nil {
+		return meta, err
+	}
+	if meta.Key == "" {
+		return meta, fmt.Errorf("metadata %s has no key", path)
+	}
+	return meta, nil
+}
+
+// ListLocalLogKeys returns the keys of the logs waiting in dir, oldest first.
+func ListLocalLogKeys(dir string) ([]string, error) {
+	logs, err := listFallbackLogs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(logs))
+	for _, fallback := range logs {
+		keys = append(keys, fallback.key)
+	}
+	return keys, nil
+}
+
+// FetchLocalLogBacklog reports how many logs are waiting in dir, their total size and the age of the oldest.
+func FetchLocalLogBacklog(dir string) (LocalLogBacklog, error) {
+	logs, err := listFallbackLogs(dir)
+	if err != nil {
+		return LocalLogBacklog{}, err
+	}
+
+	var backlog LocalLogBacklog
+	for _, fallback := range logs {
+		backlog.Files++
+		backlog.Bytes += fallback.size
+	}
+	if len(logs) > 0 {
+		oldest := logs[0].modTime
+		backlog.OldestAt = &oldest
+		backlog.OldestAgeSeconds = time.Since(oldest).Seconds()
+	}
+	return backlog, nil
+}
+
+// ReplayLocalLogs uploads the logs in LocalFallbackDir to their original keys, oldest first, and
+// deletes each file once S3 holds all of it. It stops at the first failed upload, since S3 is
+// most likely still unavailable, and returns how many logs were replayed.
+func (l *S3Logger) ReplayLocalLogs() (int, error) {
+	logs, err := listFallbackLogs(LocalFallbackDir)
+	if err != nil {
+		return 0, err
+	}
+
+	replayed := 0
+	for _, fallback := range logs {
+		if err := l.replayLocalLog(fallback); err != nil {
+			return replayed, err
+		}
+
+		log.Printf("Replayed local log to S3: %s", fallback.key)
+		replayed++
+	}
+	return replayed, nil
+}
+
+// replayLocalLog uploads a single fallback log to the key in its sidecar and deletes both files
+// once the stored object's size confirms the upload is complete, as the file is the only copy.
+// It holds fallbackMu so that a log rewritten by a newer failed upload is not deleted unseen.
+func (l *S3Logger) replayLocalLog(fallback fallbackLog) error {
+	l.fallbackMu.Lock()
+	defer l.fallbackMu.Unlock()
+
+	content, err := os.Open(fallback.path)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	info, err := content.Stat()
+	if err != nil {
+		return err
+	}
+	if err := l.uploadToS3(fallback.key, content); err != nil {
+		return err
+	}
+	if err := l.checkLogSize(fallback.key, info.Size()); err != nil {
+		return err
+	}
+	content.Close()
+	if err := os.Remove(fallback.path); err != nil {
+		return err
+	}
+	if err := os.Remove(fallback.metaPath()); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// LogReplayer periodically replays logs stranded in LocalFallbackDir back to S3.
+type LogReplayer struct {
+	logger   *S3Logger
+	interval time.Duration
+	stop     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// NewLogReplayer initializes a replayer that retries the fallback logs every interval.
+func NewLogReplayer(logger *S3Logger, interval time.Duration) *LogReplayer {
+	return &LogReplayer{
+		logger:   logger,
+		interval: interval,
+		stop:     make(chan struct{}),
+	}
+}
+
+// Start replays the fallback logs in the background until Stop is called.
+func (r *LogReplayer) Start() {
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+
+		for {
+			replayed, err := r.logger.ReplayLocalLogs()
+			if err != nil {
+				log.Printf("Replaying local logs to S3 failed after %d logs: %v", replayed, err)
+			}
+
+			select {
+			case <-r.stop:
+				return
+			case <-time.After(r.interval):
+			}
+		}
+	}()
+}
+
+// Stop stops replaying and waits for an in-progress replay to finish. It is safe to call more than once.
+func (r *LogReplayer) Stop() {
+	r.stopOnce.Do(func() { close(r.stop) })
+	r.wg.Wait()
+}
@@ -0,0 +1,257 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultLocalLogDir is where LocalLogSink stores logs when no directory is configured.
+const DefaultLocalLogDir = "logs"
+
+// unsafeKeyChars matches characters that are not safe in a key segment or a file name.
+var unsafeKeyChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// SanitizeKeySegment makes name safe to use as one segment of a log key, replacing slashes,
+// spaces and other unsafe characters with underscores.
+func SanitizeKeySegment(name string) string {
+	segment := strings.Trim(unsafeKeyChars.ReplaceAllString(name, "_"), "_")
+	if segment == "" || strings.Trim(segment, ".") == "" {
+		return "_"
+	}
+	return segment
+}
+
+// writeFileAtomic writes everything read from r to a temporary file next to path and renames it
+// into place, so readers never see a partially written file. It creates path's directory if needed.
+func writeFileAtomic(path string, r io.Reader) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*"+tempFileSuffix)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // No-op once renamed
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// tempFileSuffix marks files that writeFileAtomic has not renamed into place yet.
+const tempFileSuffix = ".tmp"
+
+// LogSink stores task logs under slash-separated keys such as logs/<task>/<run>.log.
+type LogSink interface {
+	// UploadLog streams content into key, replacing any existing log with that key. Content is
+	// seekable so that a failed upload can be retried from the start.
+	UploadLog(key string, content io.ReadSeeker) error
+	// ListLogFiles returns the keys that start with prefix, in lexical order.
+	ListLogFiles(prefix string) ([]string, error)
+	// ListLogObjects returns up to limit logs whose keys start with prefix and sort after startAfter,
+	// in lexical order. A limit of 0 or less returns them all.
+	ListLogObjects(prefix, startAfter string, limit int) ([]LogObject, error)
+	// OpenLog streams the content stored under key, decompressed if it was stored compressed.
+	// The caller must close it.
+	OpenLog(key string) (io.ReadCloser, error)
+	// OpenLogRange streams length bytes of the decompressed content under key, starting at offset.
+	// A negative length reads to the end. The caller must close it.
+	OpenLogRange(key string, offset, length int64) (io.ReadCloser, error)
+	// FetchLogContent returns the content stored under key, decompressed if it was stored compressed.
+	FetchLogContent(key string) (string, error)
+	// DeleteLog removes the log stored under key.
+	DeleteLog(key string) error
+}
+
+// LogObject describes a stored log. Size is the number of bytes stored, which is the compressed
+// size for logs uploaded with compression.
+type LogObject struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"last_modified"`
+}
+
+// readCloser pairs a reader with the closer of the stream it reads from.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// skipAndLimit discards the first offset bytes of rc and limits it to length bytes, or leaves it
+// unlimited if length is negative. It is used where the underlying stream cannot seek.
+func skipAndLimit(rc io.ReadCloser, offset, length int64) (io.ReadCloser, error) {
+	if _, err := io.CopyN(io.Discard, rc, offset); err != nil && err != io.EOF {
+		rc.Close()
+		return nil, err
+	}
+	if length < 0 {
+		return rc, nil
+	}
+	return &readCloser{Reader: io.LimitReader(rc, length), Closer: rc}, nil
+}
+
+var (
+	_ LogSink = (*LocalLogSink)(nil)
+	_ LogSink = (*S3Logger)(nil)
+)
+
+// LocalLogSink stores logs as files in a directory on the local filesystem.
+type LocalLogSink struct {
+	dir string
+}
+
+// NewLocalLogSink initializes a sink rooted at dir, creating it if needed.
+func NewLocalLogSink(dir string) *LocalLogSink {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		log.Printf("Failed to create local log directory %s: %v", dir, err)
+	}
+	return &LocalLogSink{dir: dir}
+}
+
+// path maps a key to a file under the sink's directory, rejecting keys that would escape it.
+func (s *LocalLogSink) path(key string) (string, error) {
+	if !fs.ValidPath(key) || key == "." {
+		return "", fmt.Errorf("invalid log key %q", key)
+	}
+	return filepath.Join(s.dir, filepath.FromSlash(key)), nil
+}
+
+// UploadLog writes content to the file for key.
+func (s *LocalLogSink) UploadLog(key string, content io.ReadSeeker) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := writeFileAtomic(path, content); err != nil {
+		log.Printf("Failed to write log %s: %v", path, err)
+		return err
+	}
+	return nil
+}
+
+// ListLogFiles lists the keys of all log files that start with prefix.
+func (s *LocalLogSink) ListLogFiles(prefix string) ([]string, error) {
+	objects, err := s.ListLogObjects(prefix, "", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(objects))
+	for _, object := range objects {
+		keys = append(keys, object.Key)
+	}
+	return keys, nil
+}
+
+// ListLogObjects lists the log files that start with prefix and sort after startAfter.
+func (s *LocalLogSink) ListLogObjects(prefix, startAfter string, limit int) ([]LogObject, error) {
+	var objects []LogObject
+	err := filepath.WalkDir(s.dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || strings.HasSuffix(entry.Name(), tempFileSuffix) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(s.dir, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) || key <= startAfter {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, LogObject{Key: key, Size: info.Size(), LastModified: info.ModTime()})
+		return nil
+	})
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Printf("Failed to list local logs with prefix %s: %v", prefix, err)
+		return nil, err
+	}
+
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	if limit > 0 && len(objects) > limit {
+		objects = objects[:limit]
+	}
+	return objects, nil
+}
+
+// OpenLog opens the file for key.
+func (s *LocalLogSink) OpenLog(key string) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+// OpenLogRange opens the file for key and seeks to offset.
+func (s *LocalLogSink) OpenLogRange(key string, offset, length int64) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return skipAndLimit(file, 0, length)
+}
+
+// FetchLogContent reads the file for key.
+func (s *LocalLogSink) FetchLogContent(key string) (string, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return "", err
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return string(content), nil
+}
+
+// DeleteLog removes the file for key.
+func (s *LocalLogSink) DeleteLog(key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestLocalLogSinkOpenLogRange(t *testing.T) {
+	sink := NewLocalLogSink(t.TempDir())
+	if err := sink.UploadLog("logs/1/1/1.log", strings.NewReader("0123456789")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name           string
+		key            string
+		offset, length int64
+		want           string
+		wantErr        bool
+	}{
+		{name: "whole log", key: "logs/1/1/1.log", offset: 0, length: -1, want: "0123456789"},
+		{name: "middle", key: "logs/1/1/1.log", offset: 3, length: 4, want: "3456"},
+		{name: "to the end", key: "logs/1/1/1.log", offset: 8, length: -1, want: "89"},
+		{name: "length past the end", key: "logs/1/1/1.log", offset: 8, length: 10, want: "89"},
+		{name: "offset past the end", key: "logs/1/1/1.log", offset: 20, length: -1, want: ""},
+		{name: "empty range", key: "logs/1/1/1.log", offset: 2, length: 0, want: ""},
+		{name: "missing log", key: "logs/1/1/2.log", offset: 0, length: -1, wantErr: true},
+		{name: "key escaping the directory", key: "../1.log", offset: 0, length: -1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := sink.OpenLogRange(tt.key, tt.offset, tt.length)
+			if tt.wantErr {
+				if err == nil {
+					body.Close()
+					t.Fatal("OpenLogRange succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("OpenLogRange: %v", err)
+			}
+			defer body.Close()
+
+			got, err := io.ReadAll(body)
+			if err != nil {
+				t.Fatalf("reading the range: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("OpenLogRange(%d, %d) = %q, want %q", tt.offset, tt.length, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSkipAndLimit(t *testing.T) {
+	tests := []struct {
+		name           string
+		offset, length int64
+		want           string
+	}{
+		{name: "unlimited", offset: 0, length: -1, want: "0123456789"},
+		{name: "skip and limit", offset: 2, length: 3, want: "234"},
+		{name: "skip past the end", offset: 20, length: -1, want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Not seekable, like a decompressing reader
+			body, err := skipAndLimit(io.NopCloser(strings.NewReader("0123456789")), tt.offset, tt.length)
+			if err != nil {
+				t.Fatalf("skipAndLimit: %v", err)
+			}
+			defer body.Close()
+
+			got, err := io.ReadAll(body)
+			if err != nil {
+				t.Fatalf("reading: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("skipAndLimit(%d, %d) = %q, want %q", tt.offset, tt.length, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,269 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/shammishailaj/gronicle/pkg/monitor"
+)
+
+// Defaults for MetricsWriterOptions fields left at zero.
+const (
+	DefaultMetricsBatchSize     = 200
+	DefaultMetricsFlushInterval = 2 * time.Second
+	DefaultMetricsQueueSize     = 10000
+)
+
+// MetricsRow is a system snapshot or process sample waiting to be stored in task_metrics. A RunID
+// of 0 is a run that could not be recorded and an Attempt of 0 is before the first attempt; both
+// are stored as NULL.
+type MetricsRow struct {
+	TaskID      int
+	Kind        string
+	Phase       string
+	RunID       int64
+	Attempt     int
+	CPUUsage    float64
+	RAMUsage    float64
+	DiskUsage   float64
+	LoadAverage float64
+	GPUUsage    float64
+	RSSBytes    int64
+	RecordedAt  time.Time
+}
+
+// SystemMetricsRow returns the row of a system snapshot taken in phase of a run's attempt.
+func SystemMetricsRow(taskID int, runID int64, attempt int, phase string, metrics monitor.TaskMetrics) MetricsRow {
+	return MetricsRow{
+		TaskID:      taskID,
+		Kind:        MetricsKindSystem,
+		Phase:       phase,
+		RunID:       runID,
+		Attempt:     attempt,
+		CPUUsage:    metrics.CPUUsage,
+		RAMUsage:    metrics.RAMUsage,
+		DiskUsage:   metrics.DiskUsage,
+		LoadAverage: metrics.LoadAverage,
+		GPUUsage:    metrics.GPUUsage,
+		RecordedAt:  metrics.RecordedAt,
+	}
+}
+
+// ProcessMetricsRow returns the row of a sample of the process tree of a run's attempt.
+func ProcessMetricsRow(taskID int, runID int64, attempt int, metrics monitor.ProcessMetrics) MetricsRow {
+	return MetricsRow{
+		TaskID:     taskID,
+		Kind:       MetricsKindProcess,
+		RunID:      runID,
+		Attempt:    attempt,
+		CPUUsage:   metrics.CPUUsage,
+		RAMUsage:   metrics.RAMUsage,
+		DiskUsage:  metrics.DiskUsage,
+		RSSBytes:   metrics.RSSBytes,
+		RecordedAt: metrics.RecordedAt,
+	}
+}
+
+// errNoReferencedRow is MySQL's error number for a foreign key whose referenced row does not
+// exist, such as a task deleted while its metrics were queued.
+const errNoReferencedRow = 1452
+
+// InsertTaskMetricsRows stores rows with a single multi-row INSERT and returns how many were
+// stored. If a row's task has been deleted in the meantime, the rows are retried one by one so
+// that only the orphaned ones are skipped rather than the whole batch.
+func InsertTaskMetricsRows(db *sql.DB, rows []MetricsRow) (int, error) {
+	if len(rows) == 0 {
+		return 0, nil
+	}
+
+	err := insertTaskMetricsRows(db, rows)
+	if err == nil {
+		return len(rows), nil
+	}
+	if !isNoReferencedRow(err) {
+		log.Printf("Failed to insert %d task metrics rows: %v", len(rows), err)
+		return 0, err
+	}
+
+	stored, orphaned := 0, 0
+	for i := range rows {
+		if err := insertTaskMetricsRows(db, rows[i:i+1]); err != nil {
+			if !isNoReferencedRow(err) {
+				log.Printf("Failed to insert %d task metrics rows: %v", len(rows)-i, err)
+				return stored, err
+			}
+			orphaned++
+			continue
+		}
+		stored++
+	}
+	log.Printf("Skipped %d task metrics rows of deleted tasks", orphaned)
+	return stored, nil
+}
+
+// insertTaskMetricsRows runs the multi-row INSERT of rows.
+func insertTaskMetricsRows(db *sql.DB, rows []MetricsRow) error {
+	query := `INSERT INTO task_metrics (task_id, kind, phase, run_id, attempt, cpu_usage, ram_usage, disk_usage, load_average, gpu_usage, rss_bytes, recorded_at) 
+        VALUES ` + strings.TrimSuffix(strings.Repeat("(?, ?, ?, NULLIF(?, 0), NULLIF(?, 0), ?, ?, ?, ?, ?, ?, ?), ", len(rows)), ", ")
+
+	args := make([]interface{}, 0, 12*len(rows))
+	for _, row := range rows {
+		args = append(args, row.TaskID, row.Kind, row.Phase, row.RunID, row.Attempt, row.CPUUsage, row.RAMUsage, row.DiskUsage,
+			row.LoadAverage, row.GPUUsage, row.RSSBytes, row.RecordedAt)
+	}
+
+	_, err := db.Exec(query, args...)
+	return err
+}
+
+// isNoReferencedRow reports whether err is a foreign key violation for a missing referenced row.
+func isNoReferencedRow(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == errNoReferencedRow
+}
+
+// MetricsWriterOptions configures a MetricsWriter. Zero fields take their defaults.
+type MetricsWriterOptions struct {
+	BatchSize     int           // Rows stored per INSERT; a full batch is flushed at once
+	FlushInterval time.Duration // Longest a row waits before a partial batch is flushed
+	QueueSize     int           // Rows held while MySQL is busy before new ones are dropped
+}
+
+// MetricsWriter stores task metrics in the background, batching rows into multi-row INSERTs so
+// that workers never wait on MySQL. When MySQL falls behind and the queue fills up, new rows are
+// dropped and counted rather than slowing down the runs that produce them. A nil MetricsWriter
+// discards every row.
+type MetricsWriter struct {
+	db            *sql.DB
+	queue         chan MetricsRow
+	batchSize     int
+	flushInterval time.Duration
+	dropped       atomic.Int64
+	reported      int64        // Dropped rows already logged; only used by the writer's goroutine
+	stopMu        sync.RWMutex // Held by Write while queueing, so no row is queued once Stop has begun
+	stopped       bool
+	stop          chan struct{}
+	wg            sync.WaitGroup
+}
+
+// NewMetricsWriter initializes a writer that stores metrics in db once started.
+func NewMetricsWriter(db *sql.DB, opts MetricsWriterOptions) *MetricsWriter {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultMetricsBatchSize
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = DefaultMetricsFlushInterval
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = DefaultMetricsQueueSize
+	}
+
+	return &MetricsWriter{
+		db:            db,
+		queue:         make(chan MetricsRow, opts.QueueSize),
+		batchSize:     opts.BatchSize,
+		flushInterval: opts.FlushInterval,
+		stop:          make(chan struct{}),
+	}
+}
+
+// Write queues a row to be stored. It never blocks: if the queue is full the row is dropped and
+// counted instead. It reports whether the row was queued.
+func (w *MetricsWriter) Write(row MetricsRow) bool {
+	if w == nil {
+		return false
+	}
+
+	w.stopMu.RLock()
+	defer w.stopMu.RUnlock()
+	if w.stopped {
+		w.dropped.Add(1)
+		return false
+	}
+
+	select {
+	case w.queue <- row:
+		return true
+	default:
+		w.dropped.Add(1)
+		return false
+	}
+}
+
+// Dropped returns how many rows have been dropped because the queue was full, the writer was
+// stopped or they could not be stored.
+func (w *MetricsWriter) Dropped() int64 {
+	if w == nil {
+		return 0
+	}
+	return w.dropped.Load()
+}
+
+// Start stores queued rows in the background until Stop is called.
+func (w *MetricsWriter) Start() {
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+
+		batch := make([]MetricsRow, 0, w.batchSize)
+		ticker := time.NewTicker(w.flushInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case row := <-w.queue:
+				if batch = append(batch, row); len(batch) >= w.batchSize {
+					batch = w.flush(batch)
+				}
+			case <-ticker.C:
+				batch = w.flush(batch)
+			case <-w.stop:
+				// Store whatever was queued before stopping
+				for {
+					select {
+					case row := <-w.queue:
+						if batch = append(batch, row); len(batch) >= w.batchSize {
+							batch = w.flush(batch)
+						}
+					default:
+						w.flush(batch)
+						return
+					}
+				}
+			}
+		}
+	}()
+}
+
+// flush stores batch and returns it emptied for reuse, logging any rows dropped since the last
+// flush. A batch that cannot be stored is dropped too, as holding on to it would only make the
+// queue back up further while MySQL is struggling.
+func (w *MetricsWriter) flush(batch []MetricsRow) []MetricsRow {
+	if len(batch) > 0 {
+		stored, _ := InsertTaskMetricsRows(w.db, batch)
+		w.dropped.Add(int64(len(batch) - stored))
+	}
+	if dropped := w.dropped.Load(); dropped > w.reported {
+		log.Printf("Dropped %d task metrics rows, %d in total", dropped-w.reported, dropped)
+		w.reported = dropped
+	}
+	return batch[:0]
+}
+
+// Stop stores the rows still queued and stops the writer. Rows written afterwards are dropped.
+// It is safe to call more than once.
+func (w *MetricsWriter) Stop() {
+	w.stopMu.Lock()
+	if !w.stopped {
+		w.stopped = true
+		close(w.stop)
+	}
+	w.stopMu.Unlock()
+	w.wg.Wait()
+}
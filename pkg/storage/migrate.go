@@ -0,0 +1,388 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// migrationLockName is the MySQL named lock that serializes migration runs across gronicle processes.
+const migrationLockName = "gronicle_schema_migrations"
+
+// migrationFilePattern matches migration files such as 001_init.up.sql.
+var migrationFilePattern = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// initMigrationVersion is the migration that creates the original tables. It only creates those
+// that do not exist yet, so that databases set up by hand before migrations can adopt them.
+const initMigrationVersion = 1
+
+// initColumns are the columns the init migration creates, table by table. An adopted table must
+// already have them, as later migrations and queries rely on them.
+var initColumns = []struct {
+	table   string
+	columns []string
+}{
+	{"tasks", []string{"id", "job_name", "command", "interval_seconds", "status", "start_time", "end_time", "created_at", "updated_at"}},
+	{"logs", []string{"id", "task_id", "log_message", "log_level", "created_at"}},
+	{"task_metrics", []string{"id", "task_id", "cpu_usage", "ram_usage", "disk_usage", "load_average", "gpu_usage", "recorded_at"}},
+}
+
+// Migration is a versioned schema change with its up and down SQL.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus describes whether a migration has been applied.
+type MigrationStatus struct {
+	Version   int64      `json:"version"`
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"applied_at"`
+}
+
+// LoadMigrations reads the migrations in fsys, ordered by version.
+func LoadMigrations(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		match := migrationFilePattern.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version in %s: %w", entry.Name(), err)
+		}
+
+		content, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		} else if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has conflicting names %q and %q", version, migration.Name, match[2])
+		}
+
+		if match[3] == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	var migrations []Migration
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("migration %03d_%s has no up file", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
+
+// MigrateUp applies every migration in fsys that has not been applied yet and returns the ones it applied.
+func MigrateUp(db *sql.DB, fsys fs.FS) ([]Migration, error) {
+	migrations, err := LoadMigrations(fsys)
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []Migration
+	err = withMigrationLock(db, func(ctx context.Context, conn *sql.Conn) error {
+		versions, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range migrations {
+			if _, ok := versions[migration.Version]; ok {
+				continue
+			}
+
+			if err := execStatements(ctx, conn, migration.Up); err != nil {
+				return fmt.Errorf("applying migration %03d_%s: %w", migration.Version, migration.Name, err)
+			}
+			if migration.Version == initMigrationVersion {
+				if err := checkInitColumns(ctx, conn); err != nil {
+					return fmt.Errorf("applying migration %03d_%s: %w", migration.Version, migration.Name, err)
+				}
+			}
+
+			query := `INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)`
+			if _, err := conn.ExecContext(ctx, query, migration.Version, migration.Name, time.Now()); err != nil {
+				return fmt.Errorf("recording migration %03d_%s: %w", migration.Version, migration.Name, err)
+			}
+
+			log.Printf("Applied migration %03d_%s", migration.Version, migration.Name)
+			applied = append(applied, migration)
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// MigrateDown rolls back the most recently applied steps migrations and returns the ones it rolled back.
+func MigrateDown(db *sql.DB, fsys fs.FS, steps int) ([]Migration, error) {
+	migrations, err := LoadMigrations(fsys)
+	if err != nil {
+		return nil, err
+	}
+
+	var rolledBack []Migration
+	err = withMigrationLock(db, func(ctx context.Context, conn *sql.Conn) error {
+		versions, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(migrations) - 1; i >= 0 && len(rolledBack) < steps; i-- {
+			migration := migrations[i]
+			if _, ok := versions[migration.Version]; !ok {
+				continue
+			}
+			if migration.Down == "" {
+				return fmt.Errorf("migration %03d_%s has no down file", migration.Version, migration.Name)
+			}
+
+			if err := execStatements(ctx, conn, migration.Down); err != nil {
+				return fmt.Errorf("rolling back migration %03d_%s: %w", migration.Version, migration.Name, err)
+			}
+
+			query := `DELETE FROM schema_migrations WHERE version = ?`
+			if _, err := conn.ExecContext(ctx, query, migration.Version); err != nil {
+				return fmt.Errorf("unrecording migration %03d_%s: %w", migration.Version, migration.Name, err)
+			}
+
+			log.Printf("Rolled back migration %03d_%s", migration.Version, migration.Name)
+			rolledBack = append(rolledBack, migration)
+		}
+		return nil
+	})
+	return rolledBack, err
+}
+
+// FetchMigrationStatus reports every migration in fsys and when it was applied, if at all.
+func FetchMigrationStatus(db *sql.DB, fsys fs.FS) ([]MigrationStatus, error) {
+	migrations, err := LoadMigrations(fsys)
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []MigrationStatus
+	err = withMigrationLock(db, func(ctx context.Context, conn *sql.Conn) error {
+		versions, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range migrations {
+			status := MigrationStatus{Version: migration.Version, Name: migration.Name}
+			if appliedAt, ok := versions[migration.Version]; ok {
+				status.AppliedAt = &appliedAt
+			}
+			statuses = append(statuses, status)
+		}
+		return nil
+	})
+	return statuses, err
+}
+
+// withMigrationLock runs fn on a single connection that holds the migration lock and
+// ensures the schema_migrations table exists.
+func withMigrationLock(db *sql.DB, fn func(ctx context.Context, conn *sql.Conn) error) error {
+	ctx := context.Background()
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var locked sql.NullInt64
+	if err := conn.QueryRowContext(ctx, `SELECT GET_LOCK(?, 60)`, migrationLockName).Scan(&locked); err != nil {
+		return err
+	}
+	if locked.Int64 != 1 {
+		return fmt.Errorf("timed out waiting for migration lock %s", migrationLockName)
+	}
+	defer conn.ExecContext(ctx, `SELECT RELEASE_LOCK(?)`, migrationLockName)
+
+	createTable := `
+        CREATE TABLE IF NOT EXISTS schema_migrations (
+            version BIGINT PRIMARY KEY,
+            name VARCHAR(255) NOT NULL,
+            applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+        )`
+	if _, err := conn.ExecContext(ctx, createTable); err != nil {
+		return err
+	}
+
+	return fn(ctx, conn)
+}
+
+// appliedVersions returns the applied migration versions and when each was applied.
+func appliedVersions(ctx context.Context, conn *sql.Conn) (map[int64]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := make(map[int64]time.Time)
+	for rows.Next() {
+		var version int64
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		versions[version] = appliedAt
+	}
+	return versions, rows.Err()
+}
+
+// checkInitColumns fails if a table the init migration adopted instead of creating lacks any of
+// its columns, rather than letting the database be marked as migrated and queries fail later.
+func checkInitColumns(ctx context.Context, conn *sql.Conn) error {
+	var errs []error
+	for _, table := range initColumns {
+		existing, err := tableColumns(ctx, conn, table.table)
+		if err != nil {
+			return err
+		}
+
+		var missing []string
+		for _, column := range table.columns {
+			if !existing[column] {
+				missing = append(missing, column)
+			}
+		}
+		if len(missing) > 0 {
+			errs = append(errs, fmt.Errorf("existing table %s lacks columns %s", table.table, strings.Join(missing, ", ")))
+		}
+	}
+	if len(errs) > 0 {
+		errs = append(errs, errors.New("the database predates migrations: add the missing columns as 001_init.up.sql defines them, then migrate again"))
+	}
+	return errors.Join(errs...)
+}
+
+// tableColumns returns the names of the columns of a table in the current database.
+func tableColumns(ctx context.Context, conn *sql.Conn, table string) (map[string]bool, error) {
+	query := `SELECT column_name FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name = ?`
+	rows, err := conn.QueryContext(ctx, query, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			return nil, err
+		}
+		columns[strings.ToLower(column)] = true
+	}
+	return columns, rows.Err()
+}
+
+// execStatements executes each statement in a migration file. MySQL commits DDL implicitly,
+// so statements are run one at a time rather than in a transaction.
+func execStatements(ctx context.Context, conn *sql.Conn, script string) error {
+	for _, statement := range splitStatements(script) {
+		if _, err := conn.ExecContext(ctx, statement); err != nil {
+			return fmt.Errorf("%w\n%s", err, statement)
+		}
+	}
+	return nil
+}
+
+// splitStatements splits a script into statements on semicolons, dropping comments and empty
+// statements. Semicolons inside quoted strings, quoted identifiers and comments do not end a
+// statement.
+func splitStatements(script string) []string {
+	var statements []string
+	var current strings.Builder
+	endStatement := func() {
+		if statement := strings.TrimSpace(current.String()); statement != "" {
+			statements = append(statements, statement)
+		}
+		current.Reset()
+	}
+
+	for i := 0; i < len(script); i++ {
+		switch c := script[i]; {
+		case c == '\'' || c == '"' || c == '`':
+			end := closingQuote(script, i)
+			current.WriteString(script[i:end])
+			i = end - 1
+		case c == '#' || isDashComment(script[i:]):
+			// The comment runs to the end of the line, which is kept
+			if end := strings.IndexByte(script[i:], '\n'); end >= 0 {
+				i += end - 1
+			} else {
+				i = len(script)
+			}
+		case strings.HasPrefix(script[i:], "/*"):
+			end := strings.Index(script[i+2:], "*/")
+			if end < 0 {
+				i = len(script)
+			} else {
+				i += 2 + end + 1
+			}
+			current.WriteByte(' ')
+		case c == ';':
+			endStatement()
+		default:
+			current.WriteByte(c)
+		}
+	}
+	endStatement()
+	return statements
+}
+
+// closingQuote returns the index just past the quote that closes the string or identifier opening
+// at script[start], or len(script) if it is never closed. Backslashes escape the next character
+// in strings but not in identifiers, and a doubled quote is read as two adjacent quoted parts.
+func closingQuote(script string, start int) int {
+	quote := script[start]
+	for i := start + 1; i < len(script); i++ {
+		switch {
+		case script[i] == '\\' && quote != '`':
+			i++
+		case script[i] == quote:
+			return i + 1
+		}
+	}
+	return len(script)
+}
+
+// isDashComment reports whether text starts with a -- comment, which MySQL only recognizes when
+// the dashes are followed by whitespace or the end of the script.
+func isDashComment(text string) bool {
+	if !strings.HasPrefix(text, "--") {
+		return false
+	}
+	return len(text) == 2 || strings.ContainsRune(" \t\r\n", rune(text[2]))
+}
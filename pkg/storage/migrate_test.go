@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/shammishailaj/gronicle/migrations"
+)
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   []string
+	}{
+		{
+			name:   "one per line",
+			script: "ALTER TABLE a ADD COLUMN b INT;\nALTER TABLE a ADD COLUMN c INT;\n",
+			want:   []string{"ALTER TABLE a ADD COLUMN b INT", "ALTER TABLE a ADD COLUMN c INT"},
+		},
+		{
+			name:   "spanning lines",
+			script: "CREATE TABLE a (\n    id INT\n);\n",
+			want:   []string{"CREATE TABLE a (\n    id INT\n)"},
+		},
+		{
+			name:   "several on a line",
+			script: "DROP TABLE a; DROP TABLE b;",
+			want:   []string{"DROP TABLE a", "DROP TABLE b"},
+		},
+		{
+			name:   "unterminated last statement",
+			script: "DROP TABLE a;\nDROP TABLE b\n",
+			want:   []string{"DROP TABLE a", "DROP TABLE b"},
+		},
+		{
+			name:   "semicolon in a string",
+			script: "UPDATE a SET b = 'x; y';\nUPDATE a SET c = \"p;\nq\";",
+			want:   []string{"UPDATE a SET b = 'x; y'", "UPDATE a SET c = \"p;\nq\""},
+		},
+		{
+			name:   "string ending a line with a semicolon",
+			script: "INSERT INTO a VALUES ('one;\ntwo');",
+			want:   []string{"INSERT INTO a VALUES ('one;\ntwo')"},
+		},
+		{
+			name:   "escaped and doubled quotes",
+			script: `UPDATE a SET b = 'it\'s; fine', c = 'it''s; fine';`,
+			want:   []string{`UPDATE a SET b = 'it\'s; fine', c = 'it''s; fine'`},
+		},
+		{
+			name:   "semicolon in a quoted identifier",
+			script: "ALTER TABLE `odd;name` ADD COLUMN b INT;",
+			want:   []string{"ALTER TABLE `odd;name` ADD COLUMN b INT"},
+		},
+		{
+			name:   "comment lines",
+			script: "-- Adds b; keeps c\n# and another; comment\nALTER TABLE a ADD COLUMN b INT;\n-- trailing\n",
+			want:   []string{"ALTER TABLE a ADD COLUMN b INT"},
+		},
+		{
+			name:   "quote in a comment",
+			script: "-- The task's lease\nALTER TABLE a ADD COLUMN b INT;\nALTER TABLE a ADD COLUMN c INT;",
+			want:   []string{"ALTER TABLE a ADD COLUMN b INT", "ALTER TABLE a ADD COLUMN c INT"},
+		},
+		{
+			name:   "comment after a statement",
+			script: "ALTER TABLE a ADD COLUMN b INT; -- see below; really\nALTER TABLE a ADD COLUMN c INT;",
+			want:   []string{"ALTER TABLE a ADD COLUMN b INT", "ALTER TABLE a ADD COLUMN c INT"},
+		},
+		{
+			name:   "block comment",
+			script: "ALTER TABLE a /* not; here */ ADD COLUMN b INT;",
+			want:   []string{"ALTER TABLE a   ADD COLUMN b INT"},
+		},
+		{
+			name:   "dashes without a space are not a comment",
+			script: "UPDATE a SET b = c--1;",
+			want:   []string{"UPDATE a SET b = c--1"},
+		},
+		{
+			name:   "empty statements",
+			script: ";\n;  ;\n",
+			want:   nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitStatements(tt.script); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitStatements(%q) = %q, want %q", tt.script, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMigrationsSplitIntoStatements(t *testing.T) {
+	loaded, err := LoadMigrations(migrations.FS)
+	if err != nil {
+		t.Fatalf("LoadMigrations: %v", err)
+	}
+	if len(loaded) == 0 || loaded[0].Version != initMigrationVersion {
+		t.Fatalf("the first migration is not the init migration: %+v", loaded)
+	}
+
+	statementStart := regexp.MustCompile(`^(ALTER|CREATE|DROP|UPDATE|INSERT|DELETE) `)
+	for _, migration := range loaded {
+		for _, script := range []string{migration.Up, migration.Down} {
+			statements := splitStatements(script)
+			if len(statements) == 0 {
+				t.Errorf("migration %03d_%s has a file without statements", migration.Version, migration.Name)
+			}
+			for _, statement := range statements {
+				if !statementStart.MatchString(statement) {
+					t.Errorf("migration %03d_%s: unexpected statement %q", migration.Version, migration.Name, statement)
+				}
+			}
+		}
+	}
+}
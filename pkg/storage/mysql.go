@@ -1,7 +1,10 @@
 package storage
 
 import (
+	"crypto/rand"
 	"database/sql"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"log"
 	"time"
@@ -11,17 +14,55 @@ import (
 
 // Task represents a task from the database.
 type Task struct {
-	ID        int           `json:"id"`
-	JobName   string        `json:"job_name"`
-	Command   string        `json:"command"`
-	Interval  time.Duration `json:"interval_seconds"`
-	Status    string        `json:"status"`
-	CreatedAt string        `json:"created_at"`
+	ID             int            `json:"id"`
+	JobName        string         `json:"job_name"`
+	Command        string         `json:"command"`
+	Interval       time.Duration  `json:"interval_seconds"`
+	Schedule       string         `json:"schedule,omitempty"`
+	Timezone       string         `json:"timezone"`
+	TimeoutSeconds int            `json:"timeout_seconds"`                   // 0 means no timeout
+	SampleInterval int            `json:"sample_interval_seconds,omitempty"` // Seconds between samples of the task's processes; 0 uses the server's interval
+	RedactPatterns StringList     `json:"redact_patterns,omitempty"`         // Regular expressions masked in the task's output
+	Env            StringMap      `json:"env,omitempty"`                     // Environment variables set for every run
+	SecretEnv      StringList     `json:"secret_env,omitempty"`              // Names of variables whose values are masked in the output
+	WorkingDir     string         `json:"working_dir,omitempty"`             // Directory the command runs in; empty for the server's
+	RunAsUser      string         `json:"run_as_user,omitempty"`             // User name or UID the command runs as; empty for the server's
+	RunAsGroup     string         `json:"run_as_group,omitempty"`            // Group name or GID; empty for the user's primary group
+	Limits         ResourceLimits `json:"limits"`                            // cgroup v2 limits applied to every attempt
+	Status         string         `json:"status"`
+	NextRunAt      *time.Time     `json:"next_run_at"`
+	LeaseOwner     string         `json:"-"`
+	LeaseToken     string         `json:"-"` // Identifies the claim, so a stale copy of an earlier claim cannot renew the lease
+	CreatedAt      string         `json:"created_at"`
 }
 
-// ConnectMySQL connects to the MySQL database
+// MarshalJSON encodes Interval in whole seconds, as its interval_seconds name says.
+func (t Task) MarshalJSON() ([]byte, error) {
+	type task Task // Without Task's methods, so encoding it does not recurse
+	return json.Marshal(struct {
+		task
+		Interval int `json:"interval_seconds"`
+	}{task(t), int(t.Interval / time.Second)})
+}
+
+// ResourceLimits are the cgroup v2 limits a task's commands run under. Zero values are unlimited.
+type ResourceLimits struct {
+	CPU            float64 `json:"cpu,omitempty"`              // CPU cores, e.g. 0.5 for half a core
+	MemoryMaxBytes int64   `json:"memory_max_bytes,omitempty"` // Memory above this is reclaimed, then OOM-killed
+	PidsMax        int     `json:"pids_max,omitempty"`         // Maximum number of processes and threads
+	IOWeight       int     `json:"io_weight,omitempty"`        // Relative IO weight, 1-10000 (default 100)
+}
+
+// IsZero reports whether no limit is set.
+func (l ResourceLimits) IsZero() bool {
+	return l == ResourceLimits{}
+}
+
+// ConnectMySQL connects to the MySQL database.
+// clientFoundRows makes RowsAffected count matched rows, which RenewTaskLease relies on
+// when a renewal does not change the stored expiry.
 func ConnectMySQL(user, password, host, dbName string) *sql.DB {
-	dsn := fmt.Sprintf("%s:%s@tcp(%s)/%s?parseTime=true", user, password, host, dbName)
+	dsn := fmt.Sprintf("%s:%s@tcp(%s)/%s?parseTime=true&clientFoundRows=true", user, password, host, dbName)
 	db, err := sql.Open("mysql", dsn)
 	if err != nil {
 		log.Fatalf("Could not connect to MySQL: %v", err)
@@ -29,35 +70,121 @@ func ConnectMySQL(user, password, host, dbName string) *sql.DB {
 	return db
 }
 
-// FetchPendingTasks fetches tasks from the database with 'pending' or 'running' status.
-func FetchPendingTasks(db *sql.DB) ([]Task, error) {
+// ClaimDueTasks atomically claims up to limit tasks whose next_run_at has been reached and
+// which are not leased by another scheduler. Claimed tasks are marked 'running', leased to
+// owner until now+leaseFor under a new LeaseToken and rescheduled to the time returned by
+// nextRunAt, all in one transaction. Rows locked by a concurrent claim are skipped (MySQL 8.0+), so several
+// gronicle processes can share one database without running the same due run twice.
+func ClaimDueTasks(db *sql.DB, owner string, now time.Time, leaseFor time.Duration, limit int, nextRunAt func(*Task) *time.Time) ([]Task, error) {
+	if limit <= 0 {
+		return nil, nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
 	query := `
-        SELECT id, job_name, command, interval_seconds 
+        SELECT id, job_name, command, interval_seconds, schedule, timezone, timeout_seconds, sample_interval_seconds, redact_patterns, env, secret_env, working_dir, run_as_user, run_as_group, 
+            cpu_limit, memory_max_bytes, pids_max, io_weight, next_run_at 
         FROM tasks 
-        WHERE status IN ('pending', 'running')`
+        WHERE next_run_at IS NOT NULL AND next_run_at <= ?
+        AND (lease_expires_at IS NULL OR lease_expires_at <= ?)
+        ORDER BY next_run_at
+        LIMIT ?
+        FOR UPDATE SKIP LOCKED`
 
-	rows, err := db.Query(query)
+	rows, err := tx.Query(query, now, now, limit)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 
 	var tasks []Task
 	for rows.Next() {
 		var task Task
 		var intervalSeconds int
 
-		if err := rows.Scan(&task.ID, &task.JobName, &task.Command, &intervalSeconds); err != nil {
+		if err := rows.Scan(&task.ID, &task.JobName, &task.Command, &intervalSeconds, &task.Schedule, &task.Timezone, &task.TimeoutSeconds, &task.SampleInterval, &task.RedactPatterns, &task.Env, &task.SecretEnv, &task.WorkingDir, &task.RunAsUser, &task.RunAsGroup,
+			&task.Limits.CPU, &task.Limits.MemoryMaxBytes, &task.Limits.PidsMax, &task.Limits.IOWeight, &task.NextRunAt); err != nil {
+			rows.Close()
 			return nil, err
 		}
 
 		task.Interval = time.Duration(intervalSeconds) * time.Second
 		tasks = append(tasks, task)
 	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	claim := `UPDATE tasks 
+        SET lease_owner = ?, lease_token = ?, lease_expires_at = ?, next_run_at = ?, status = 'running' 
+        WHERE id = ?`
+
+	expiresAt := now.Add(leaseFor)
+	for i := range tasks {
+		token, err := newLeaseToken()
+		if err != nil {
+			return nil, err
+		}
+		tasks[i].LeaseOwner = owner
+		tasks[i].LeaseToken = token
+		if _, err := tx.Exec(claim, owner, token, expiresAt, nextRunAt(&tasks[i]), tasks[i].ID); err != nil {
+			return nil, err
+		}
+	}
 
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
 	return tasks, nil
 }
 
+// newLeaseToken returns a random token identifying one claim of a task.
+func newLeaseToken() (string, error) {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(token), nil
+}
+
+// RenewTaskLease extends the lease of the task's claim until now+leaseFor. It reports false if
+// the claim no longer holds the lease: because it expired, even if no one has claimed the task
+// since, or because the task was claimed again, possibly by the same owner.
+func RenewTaskLease(db *sql.DB, task *Task, now time.Time, leaseFor time.Duration) (bool, error) {
+	query := `UPDATE tasks SET lease_expires_at = ? 
+        WHERE id = ? AND lease_owner = ? AND lease_token = ? AND lease_expires_at > ?`
+	result, err := db.Exec(query, now.Add(leaseFor), task.ID, task.LeaseOwner, task.LeaseToken, now)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+// ReleaseTaskLease releases the lease of the task's claim so it can be claimed on its next run.
+// A lease that has since been claimed again is left alone.
+func ReleaseTaskLease(db *sql.DB, task *Task) error {
+	query := `UPDATE tasks SET lease_owner = NULL, lease_token = NULL, lease_expires_at = NULL 
+        WHERE id = ? AND lease_owner = ? AND lease_token = ?`
+	_, err := db.Exec(query, task.ID, task.LeaseOwner, task.LeaseToken)
+	return err
+}
+
+// UnclaimTask releases the lease of a task's claim that never started and puts the task back
+// to being due at its NextRunAt, so the run is picked up again rather than skipped.
+func UnclaimTask(db *sql.DB, task *Task) error {
+	query := `UPDATE tasks 
+        SET lease_owner = NULL, lease_token = NULL, lease_expires_at = NULL, next_run_at = ?, status = 'pending' 
+        WHERE id = ? AND lease_owner = ? AND lease_token = ?`
+	_, err := db.Exec(query, task.NextRunAt, task.ID, task.LeaseOwner, task.LeaseToken)
+	return err
+}
+
 // UpdateTaskStatus updates the status of a task (e.g., after execution).
 func UpdateTaskStatus(db *sql.DB, taskID int, status string) error {
 	query := `UPDATE tasks SET status = ?, updated_at = NOW() WHERE id = ?`
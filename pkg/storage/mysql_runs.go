@@ -0,0 +1,277 @@
+package storage
+
+import (
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/shammishailaj/gronicle/pkg/monitor"
+)
+
+// TaskRun is one execution of a task, covering all of its retry attempts.
+type TaskRun struct {
+	ID          int64                 `json:"id"`
+	TaskID      int                   `json:"task_id"`
+	Status      string                `json:"status"`
+	TriggeredBy string                `json:"triggered_by"` // "schedule" or "manual"
+	WorkerID    int                   `json:"worker_id"`
+	Host        string                `json:"host"`
+	LogKey      string                `json:"log_key"`
+	StartTime   *time.Time            `json:"start_time"`
+	EndTime     *time.Time            `json:"end_time"`
+	OOMKills    int                   `json:"oom_kills"` // Processes the kernel OOM-killed across all attempts
+	Usage       monitor.ResourceUsage `json:"usage"`     // Totals across all attempts; the peak RSS is the highest of any attempt
+	Attempts    []TaskRunAttempt      `json:"attempts,omitempty"`
+}
+
+// TaskRunAttempt is a single attempt at executing a task run.
+type TaskRunAttempt struct {
+	ID        int64      `json:"id"`
+	RunID     int64      `json:"run_id"`
+	Attempt   int        `json:"attempt"`
+	Status    string     `json:"status"`
+	ExitCode  *int       `json:"exit_code"`
+	Signal    string     `json:"signal,omitempty"`
+	OOMKills  int        `json:"oom_kills"` // Processes the kernel OOM-killed for exceeding the task's memory limit
+	LogKey    string     `json:"log_key"`   // The attempt's output log
+	StartTime *time.Time `json:"start_time"`
+	EndTime   *time.Time `json:"end_time"`
+}
+
+// InsertTaskRun records the start of a run and returns its run ID.
+func InsertTaskRun(db *sql.DB, taskID, workerID int, host string, startTime time.Time) (int64, error) {
+	query := `INSERT INTO task_runs (task_id, status, worker_id, host, start_time) 
+        VALUES (?, 'running', ?, ?, ?)`
+
+	result, err := db.Exec(query, taskID, workerID, host, startTime)
+	if err != nil {
+		log.Printf("Failed to insert run for task %d: %v", taskID, err)
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// InsertQueuedTaskRun records a run that has been requested but not yet picked up by a worker
+// and returns its run ID.
+func InsertQueuedTaskRun(db *sql.DB, taskID int, triggeredBy string) (int64, error) {
+	query := `INSERT INTO task_runs (task_id, status, triggered_by, worker_id, host) 
+        VALUES (?, 'queued', ?, 0, '')`
+
+	result, err := db.Exec(query, taskID, triggeredBy)
+	if err != nil {
+		log.Printf("Failed to insert queued run for task %d: %v", taskID, err)
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// StartTaskRun records that a worker has started a queued run. It reports false if the run is no
+// longer queued, e.g. because it was cancelled before a worker picked it up.
+func StartTaskRun(db *sql.DB, runID int64, workerID int, host string, startTime time.Time) (bool, error) {
+	query := `UPDATE task_runs SET status = 'running', worker_id = ?, host = ?, start_time = ? WHERE id = ? AND status = 'queued'`
+
+	result, err := db.Exec(query, workerID, host, startTime, runID)
+	if err != nil {
+		log.Printf("Failed to start run %d: %v", runID, err)
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+// CancelQueuedTaskRun marks a run that has not been picked up by a worker yet as cancelled, so
+// it is skipped when its turn comes. It reports false if the run is not queued.
+func CancelQueuedTaskRun(db *sql.DB, runID int64, endTime time.Time) (bool, error) {
+	query := `UPDATE task_runs SET status = 'cancelled', end_time = ? WHERE id = ? AND status = 'queued'`
+
+	result, err := db.Exec(query, endTime, runID)
+	if err != nil {
+		log.Printf("Failed to cancel queued run %d: %v", runID, err)
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+// CancelQueuedTaskRuns marks every queued run of a task as cancelled and returns how many there were.
+func CancelQueuedTaskRuns(db *sql.DB, taskID int, endTime time.Time) (int, error) {
+	query := `UPDATE task_runs SET status = 'cancelled', end_time = ? WHERE task_id = ? AND status = 'queued'`
+
+	result, err := db.Exec(query, endTime, taskID)
+	if err != nil {
+		log.Printf("Failed to cancel queued runs of task %d: %v", taskID, err)
+		return 0, err
+	}
+	affected, err := result.RowsAffected()
+	return int(affected), err
+}
+
+// FinishTaskRun records the outcome of a run.
+func FinishTaskRun(db *sql.DB, runID int64, status string, endTime time.Time, logKey string) error {
+	query := `UPDATE task_runs SET status = ?, end_time = ?, log_key = ? WHERE id = ?`
+
+	_, err := db.Exec(query, status, endTime, logKey, runID)
+	if err != nil {
+		log.Printf("Failed to finish run %d: %v", runID, err)
+	}
+	return err
+}
+
+// InsertTaskRunAttempt records the start of an attempt within a run and returns its ID.
+func InsertTaskRunAttempt(db *sql.DB, runID int64, attempt int, startTime time.Time) (int64, error) {
+	query := `INSERT INTO task_run_attempts (run_id, attempt, status, start_time) 
+        VALUES (?, ?, 'running', ?)`
+
+	result, err := db.Exec(query, runID, attempt, startTime)
+	if err != nil {
+		log.Printf("Failed to insert attempt %d for run %d: %v", attempt, runID, err)
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// FinishTaskRunAttempt records the outcome of an attempt. exitCode is nil if the command never
+// started, signal names the signal that terminated it, if any, and oomKills counts the processes
+// the kernel OOM-killed during the attempt, which are added to the run's total too.
+func FinishTaskRunAttempt(db *sql.DB, attemptID int64, status string, exitCode *int, signal string, oomKills int, endTime time.Time, logKey string) error {
+	query := `UPDATE task_run_attempts SET status = ?, exit_code = ?, signal_name = ?, oom_kills = ?, end_time = ?, log_key = ? WHERE id = ?`
+
+	_, err := db.Exec(query, status, exitCode, signal, oomKills, endTime, logKey, attemptID)
+	if err != nil {
+		log.Printf("Failed to finish attempt %d: %v", attemptID, err)
+		return err
+	}
+
+	if oomKills > 0 {
+		runQuery := `UPDATE task_runs SET oom_kills = oom_kills + ? 
+            WHERE id = (SELECT run_id FROM task_run_attempts WHERE id = ?)`
+		if _, err = db.Exec(runQuery, oomKills, attemptID); err != nil {
+			log.Printf("Failed to record OOM kills of attempt %d on its run: %v", attemptID, err)
+		}
+	}
+	return err
+}
+
+// AddTaskRunUsage adds what an attempt's processes consumed to its run's totals.
+func AddTaskRunUsage(db *sql.DB, runID int64, usage monitor.ResourceUsage) error {
+	query := `UPDATE task_runs SET 
+            cpu_user_seconds = cpu_user_seconds + ?, 
+            cpu_system_seconds = cpu_system_seconds + ?, 
+            peak_rss_bytes = GREATEST(peak_rss_bytes, ?), 
+            read_bytes = read_bytes + ?, 
+            write_bytes = write_bytes + ?, 
+            voluntary_ctx_switches = voluntary_ctx_switches + ?, 
+            involuntary_ctx_switches = involuntary_ctx_switches + ? 
+        WHERE id = ?`
+
+	_, err := db.Exec(query, usage.CPUUserSeconds, usage.CPUSystemSeconds, usage.PeakRSSBytes, usage.ReadBytes, usage.WriteBytes,
+		usage.VoluntaryCtxSwitches, usage.InvoluntaryCtxSwitches, runID)
+	if err != nil {
+		log.Printf("Failed to record resource usage of run %d: %v", runID, err)
+	}
+	return err
+}
+
+// FetchTaskRuns retrieves the runs of a task, most recent first.
+func FetchTaskRuns(db *sql.DB, taskID int) ([]TaskRun, error) {
+	query := `SELECT id, task_id, status, triggered_by, worker_id, host, log_key, start_time, end_time, oom_kills, 
+            cpu_user_seconds, cpu_system_seconds, peak_rss_bytes, read_bytes, write_bytes, 
+            voluntary_ctx_switches, involuntary_ctx_switches 
+        FROM task_runs 
+        WHERE task_id = ? 
+        ORDER BY id DESC`
+
+	rows, err := db.Query(query, taskID)
+	if err != nil {
+		log.Printf("Failed to fetch runs for task %d: %v", taskID, err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var runs []TaskRun
+	for rows.Next() {
+		var run TaskRun
+		if err := rows.Scan(&run.ID, &run.TaskID, &run.Status, &run.TriggeredBy, &run.WorkerID, &run.Host, &run.LogKey, &run.StartTime, &run.EndTime, &run.OOMKills,
+			&run.Usage.CPUUserSeconds, &run.Usage.CPUSystemSeconds, &run.Usage.PeakRSSBytes, &run.Usage.ReadBytes, &run.Usage.WriteBytes,
+			&run.Usage.VoluntaryCtxSwitches, &run.Usage.InvoluntaryCtxSwitches); err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
+
+// FetchTaskRunByID retrieves a run together with all of its attempts.
+func FetchTaskRunByID(db *sql.DB, runID int64) (*TaskRun, error) {
+	query := `SELECT id, task_id, status, triggered_by, worker_id, host, log_key, start_time, end_time, oom_kills, 
+            cpu_user_seconds, cpu_system_seconds, peak_rss_bytes, read_bytes, write_bytes, 
+            voluntary_ctx_switches, involuntary_ctx_switches 
+        FROM task_runs 
+        WHERE id = ?`
+
+	var run TaskRun
+	err := db.QueryRow(query, runID).Scan(&run.ID, &run.TaskID, &run.Status, &run.TriggeredBy, &run.WorkerID, &run.Host, &run.LogKey, &run.StartTime, &run.EndTime, &run.OOMKills,
+		&run.Usage.CPUUserSeconds, &run.Usage.CPUSystemSeconds, &run.Usage.PeakRSSBytes, &run.Usage.ReadBytes, &run.Usage.WriteBytes,
+		&run.Usage.VoluntaryCtxSwitches, &run.Usage.InvoluntaryCtxSwitches)
+	if err != nil {
+		return nil, err
+	}
+
+	attemptsQuery := `SELECT id, run_id, attempt, status, exit_code, signal_name, oom_kills, log_key, start_time, end_time 
+        FROM task_run_attempts 
+        WHERE run_id = ? 
+        ORDER BY attempt`
+
+	rows, err := db.Query(attemptsQuery, runID)
+	if err != nil {
+		log.Printf("Failed to fetch attempts for run %d: %v", runID, err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var attempt TaskRunAttempt
+		if err := rows.Scan(&attempt.ID, &attempt.RunID, &attempt.Attempt, &attempt.Status, &attempt.ExitCode, &attempt.Signal, &attempt.OOMKills, &attempt.LogKey, &attempt.StartTime, &attempt.EndTime); err != nil {
+			return nil, err
+		}
+		run.Attempts = append(run.Attempts, attempt)
+	}
+	return &run, rows.Err()
+}
+
+// FetchRunEndedAfter returns the ID of the task's first run that ended within window after t, or 0 if there is none.
+func FetchRunEndedAfter(db *sql.DB, taskID int, t time.Time, window time.Duration) (int64, error) {
+	query := `SELECT id FROM task_runs 
+        WHERE task_id = ? AND end_time >= ? AND end_time <= ? 
+        ORDER BY end_time 
+        LIMIT 1`
+
+	var runID int64
+	err := db.QueryRow(query, taskID, t, t.Add(window)).Scan(&runID)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return runID, err
+}
+
+// FetchLastAttemptNumber returns the number of the run's last attempt, or 0 if it has none.
+func FetchLastAttemptNumber(db *sql.DB, runID int64) (int, error) {
+	query := `SELECT COALESCE(MAX(attempt), 0) FROM task_run_attempts WHERE run_id = ?`
+
+	var attempt int
+	err := db.QueryRow(query, runID).Scan(&attempt)
+	return attempt, err
+}
+
+// ReplaceLogKey points runs and attempts that reference oldKey at newKey.
+func ReplaceLogKey(db *sql.DB, oldKey, newKey string) error {
+	if _, err := db.Exec(`UPDATE task_runs SET log_key = ? WHERE log_key = ?`, newKey, oldKey); err != nil {
+		log.Printf("Failed to replace log key %s on runs: %v", oldKey, err)
+		return err
+	}
+	if _, err := db.Exec(`UPDATE task_run_attempts SET log_key = ? WHERE log_key = ?`, newKey, oldKey); err != nil {
+		log.Printf("Failed to replace log key %s on attempts: %v", oldKey, err)
+		return err
+	}
+	return nil
+}
@@ -8,9 +8,13 @@ import (
 )
 
 // InsertTask inserts a new task into the database.
-func InsertTask(db *sql.DB, jobName, command string, intervalSeconds int) (int64, error) {
-	query := "INSERT INTO tasks (job_name, command, interval_seconds) VALUES (?, ?, ?)"
-	result, err := db.Exec(query, jobName, command, intervalSeconds)
+func InsertTask(db *sql.DB, task *Task) (int64, error) {
+	query := `INSERT INTO tasks (job_name, command, interval_seconds, schedule, timezone, timeout_seconds, sample_interval_seconds, redact_patterns, env, secret_env, working_dir, run_as_user, run_as_group, 
+            cpu_limit, memory_max_bytes, pids_max, io_weight, next_run_at) 
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	intervalSeconds := int(task.Interval / time.Second)
+	result, err := db.Exec(query, task.JobName, task.Command, intervalSeconds, task.Schedule, task.Timezone, task.TimeoutSeconds, task.SampleInterval, task.RedactPatterns, task.Env, task.SecretEnv, task.WorkingDir, task.RunAsUser, task.RunAsGroup,
+		task.Limits.CPU, task.Limits.MemoryMaxBytes, task.Limits.PidsMax, task.Limits.IOWeight, task.NextRunAt)
 	if err != nil {
 		log.Printf("Failed to insert task: %v", err)
 		return 0, err
@@ -20,7 +24,7 @@ func InsertTask(db *sql.DB, jobName, command string, intervalSeconds int) (int64
 
 // FetchAllTasks retrieves all tasks from the database.
 func FetchAllTasks(db *sql.DB) ([]Task, error) {
-	query := "SELECT id, job_name, command, interval_seconds, status, created_at FROM tasks"
+	query := "SELECT id, job_name, command, interval_seconds, schedule, timezone, timeout_seconds, sample_interval_seconds, redact_patterns, env, secret_env, working_dir, run_as_user, run_as_group, cpu_limit, memory_max_bytes, pids_max, io_weight, status, next_run_at, created_at FROM tasks"
 	rows, err := db.Query(query)
 	if err != nil {
 		log.Printf("Failed to fetch tasks: %v", err)
@@ -31,9 +35,11 @@ func FetchAllTasks(db *sql.DB) ([]Task, error) {
 	var tasks []Task
 	for rows.Next() {
 		var task Task
-		if err := rows.Scan(&task.ID, &task.JobName, &task.Command, &task.Interval, &task.Status, &task.CreatedAt); err != nil {
+		var intervalSeconds int
+		if err := rows.Scan(&task.ID, &task.JobName, &task.Command, &intervalSeconds, &task.Schedule, &task.Timezone, &task.TimeoutSeconds, &task.SampleInterval, &task.RedactPatterns, &task.Env, &task.SecretEnv, &task.WorkingDir, &task.RunAsUser, &task.RunAsGroup, &task.Limits.CPU, &task.Limits.MemoryMaxBytes, &task.Limits.PidsMax, &task.Limits.IOWeight, &task.Status, &task.NextRunAt, &task.CreatedAt); err != nil {
 			return nil, err
 		}
+		task.Interval = time.Duration(intervalSeconds) * time.Second
 		tasks = append(tasks, task)
 	}
 	return tasks, nil
@@ -41,12 +47,14 @@ func FetchAllTasks(db *sql.DB) ([]Task, error) {
 
 // FetchTaskByID retrieves a specific task by ID.
 func FetchTaskByID(db *sql.DB, id int) (*Task, error) {
-	query := "SELECT id, job_name, command, interval_seconds, status, created_at FROM tasks WHERE id = ?"
+	query := "SELECT id, job_name, command, interval_seconds, schedule, timezone, timeout_seconds, sample_interval_seconds, redact_patterns, env, secret_env, working_dir, run_as_user, run_as_group, cpu_limit, memory_max_bytes, pids_max, io_weight, status, next_run_at, created_at FROM tasks WHERE id = ?"
 	var task Task
-	err := db.QueryRow(query, id).Scan(&task.ID, &task.JobName, &task.Command, &task.Interval, &task.Status, &task.CreatedAt)
+	var intervalSeconds int
+	err := db.QueryRow(query, id).Scan(&task.ID, &task.JobName, &task.Command, &intervalSeconds, &task.Schedule, &task.Timezone, &task.TimeoutSeconds, &task.SampleInterval, &task.RedactPatterns, &task.Env, &task.SecretEnv, &task.WorkingDir, &task.RunAsUser, &task.RunAsGroup, &task.Limits.CPU, &task.Limits.MemoryMaxBytes, &task.Limits.PidsMax, &task.Limits.IOWeight, &task.Status, &task.NextRunAt, &task.CreatedAt)
 	if err == sql.ErrNoRows {
 		return nil, err
 	}
+	task.Interval = time.Duration(intervalSeconds) * time.Second
 	return &task, err
 }
 
@@ -87,28 +95,80 @@ func FetchTaskMetrics(db *sql.DB) ([]TaskMetrics, error) {
 	return metrics, nil
 }
 
-// FetchTaskMetrics retrieves metrics for a specific task from the database.
-func FetchTaskMetricsV2(db *sql.DB, taskID int) ([]monitor.TaskMetrics, error) {
-	query := `SELECT cpu_usage, ram_usage, disk_usage, load_average, gpu_usage, recorded_at 
+// Kinds of stored task metrics.
+const (
+	MetricsKindSystem  = "system"  // Snapshot of the whole host taken around an attempt
+	MetricsKindProcess = "process" // Sample of the task's process tree taken while an attempt runs
+)
+
+// Phases of system snapshots.
+const (
+	MetricsPhasePre     = "pre"     // Before the run's first attempt
+	MetricsPhasePost    = "post"    // After an attempt that succeeded
+	MetricsPhaseFailure = "failure" // After an attempt that failed
+)
+
+// TaskMetricsRecord is a stored system snapshot or process sample of a task. Records stored before
+// metrics were tied to runs have no run ID or attempt.
+type TaskMetricsRecord struct {
+	Kind     string `json:"kind"`
+	Phase    string `json:"phase,omitempty"` // Only set for system snapshots
+	RunID    *int64 `json:"run_id"`
+	Attempt  *int   `json:"attempt"`             // nil for the snapshot taken before the first attempt
+	RSSBytes int64  `json:"rss_bytes,omitempty"` // Only set for process samples
+	monitor.TaskMetrics
+}
+
+// TaskMetricsFilter selects the metrics FetchTaskMetricsV2 returns. Zero fields match everything.
+type TaskMetricsFilter struct {
+	Kind    string
+	Phase   string
+	RunID   int64
+	Attempt int
+}
+
+// FetchTaskMetricsV2 retrieves the metrics of a task that match filter, oldest first.
+func FetchTaskMetricsV2(db *sql.DB, taskID int, filter TaskMetricsFilter) ([]TaskMetricsRecord, error) {
+	query := `SELECT kind, phase, run_id, attempt, cpu_usage, ram_usage, disk_usage, load_average, gpu_usage, rss_bytes, recorded_at 
         FROM task_metrics 
         WHERE task_id = ?`
+	args := []interface{}{taskID}
 
-	rows, err := db.Query(query, taskID)
+	if filter.Kind != "" {
+		query += ` AND kind = ?`
+		args = append(args, filter.Kind)
+	}
+	if filter.Phase != "" {
+		query += ` AND phase = ?`
+		args = append(args, filter.Phase)
+	}
+	if filter.RunID != 0 {
+		query += ` AND run_id = ?`
+		args = append(args, filter.RunID)
+	}
+	if filter.Attempt != 0 {
+		query += ` AND attempt = ?`
+		args = append(args, filter.Attempt)
+	}
+	query += ` ORDER BY recorded_at, id`
+
+	rows, err := db.Query(query, args...)
 	if err != nil {
 		log.Printf("Failed to fetch metrics for task %d: %v", taskID, err)
 		return nil, err
 	}
 	defer rows.Close()
 
-	var metrics []monitor.TaskMetrics
+	var metrics []TaskMetricsRecord
 	for rows.Next() {
-		var metric monitor.TaskMetrics
-		if err := rows.Scan(&metric.CPUUsage, &metric.RAMUsage, &metric.DiskUsage, &metric.LoadAverage, &metric.GPUUsage, &metric.RecordedAt); err != nil {
+		var metric TaskMetricsRecord
+		if err := rows.Scan(&metric.Kind, &metric.Phase, &metric.RunID, &metric.Attempt, &metric.CPUUsage, &metric.RAMUsage, &metric.DiskUsage,
+			&metric.LoadAverage, &metric.GPUUsage, &metric.RSSBytes, &metric.RecordedAt); err != nil {
 			return nil, err
 		}
 		metrics = append(metrics, metric)
 	}
-	return metrics, nil
+	return metrics, rows.Err()
 }
 
 // UpdateTaskExecution updates the task's execution timestamps and status.
@@ -157,28 +217,3 @@ func FetchEnhancedMetrics(db *sql.DB) (map[string]interface{}, error) {
 
 	return metrics, nil
 }
-
-// InsertTaskMetrics stores the collected metrics for a task execution.
-func InsertTaskMetrics(db *sql.DB, taskID int, metrics monitor.TaskMetrics) error {
-	query := `
-        INSERT INTO task_metrics (task_id, cpu_usage, ram_usage, disk_usage, load_average, gpu_usage, recorded_at) 
-        VALUES (?, ?, ?, ?, ?, ?, ?)`
-
-	_, err := db.Exec(query, taskID, metrics.CPUUsage, metrics.RAMUsage, metrics.DiskUsage, metrics.LoadAverage, metrics.GPUUsage, metrics.RecordedAt)
-	if err != nil {
-		log.Printf("Failed to insert task metrics for task %d: %v", taskID, err)
-	}
-	return err
-}
-
-// InsertProcessTaskMetrics stores per-process metrics for a task execution.
-func InsertProcessTaskMetrics(db *sql.DB, taskID int, metrics monitor.ProcessMetrics) error {
-	query := `INSERT INTO task_metrics (task_id, cpu_usage, ram_usage, disk_usage, recorded_at) 
-        VALUES (?, ?, ?, ?, ?)`
-
-	_, err := db.Exec(query, taskID, metrics.CPUUsage, metrics.RAMUsage, metrics.DiskUsage, metrics.RecordedAt)
-	if err != nil {
-		log.Printf("Failed to insert process metrics for task %d: %v", taskID, err)
-	}
-	return err
-}
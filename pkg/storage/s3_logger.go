@@ -2,42 +2,90 @@ package storage
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"log"
 	"math"
-	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 )
 
 // Max retries for S3 uploads
 const maxRetries = 3
 
-// S3Logger uploads logs to S3
+// originalSizeMetadata is the object metadata key that records a log's uncompressed size in bytes.
+const originalSizeMetadata = "original-size"
+
+// gzipEncoding is the Content-Encoding of logs uploaded with compression.
+const gzipEncoding = "gzip"
+
+// LocalFallbackDir holds logs that could not be uploaded to S3 until they are replayed.
+const LocalFallbackDir = "local_logs"
+
+// S3Logger is a LogSink that uploads logs to S3 or an S3-compatible store such as MinIO
 type S3Logger struct {
-	client *s3.Client
-	bucket string
+	client   *s3.Client
+	uploader *manager.Uploader
+	bucket   string
+	compress bool
+
+	// fallbackMu keeps replay from reading a fallback file while it is still being written.
+	fallbackMu sync.Mutex
+}
+
+// S3Options configures an S3Logger.
+type S3Options struct {
+	Bucket string
+	Region string
+	// Endpoint overrides the AWS endpoint, e.g. http://minio.internal:9000 for an S3-compatible store.
+	Endpoint string
+	// UsePathStyle addresses objects as <endpoint>/<bucket>/<key> instead of <bucket>.<endpoint>/<key>,
+	// which most S3-compatible stores require.
+	UsePathStyle bool
+	// Compress gzips logs on upload. They are decompressed transparently when fetched.
+	Compress bool
 }
 
 // NewS3Logger initializes the logger with AWS SDK V2
 func NewS3Logger(bucket, region string) *S3Logger {
-	cfg, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion(region))
+	return NewS3LoggerWithOptions(S3Options{Bucket: bucket, Region: region})
+}
+
+// NewS3LoggerWithOptions initializes the logger with AWS SDK V2, optionally against a custom endpoint
+func NewS3LoggerWithOptions(opts S3Options) *S3Logger {
+	cfg, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion(opts.Region))
 	if err != nil {
 		log.Fatalf("Unable to load AWS SDK config: %v", err)
 	}
 
-	client := s3.NewFromConfig(cfg)
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if opts.Endpoint != "" {
+			o.BaseEndpoint = awsString(opts.Endpoint)
+		}
+		o.UsePathStyle = opts.UsePathStyle
+	})
 	return &S3Logger{
-		client: client,
-		bucket: bucket,
+		client:   client,
+		uploader: manager.NewUploader(client),
+		bucket:   opts.Bucket,
+		compress: opts.Compress,
 	}
 }
 
 // UploadLog retries S3 log upload with exponential backoff and falls back to local storage if all retries fail.
-func (l *S3Logger) UploadLog(filename string, content string) {
+// It only returns an error if the log could not be saved locally either.
+func (l *S3Logger) UploadLog(filename string, content io.ReadSeeker) error {
 	var err error
 	for attempt := 1; attempt <= maxRetries; attempt++ {
 		log.Printf("Attempt %d to upload log to S3: %s", attempt, filename)
@@ -45,7 +93,7 @@ func (l *S3Logger) UploadLog(filename string, content string) {
 		err = l.uploadToS3(filename, content)
 		if err == nil {
 			log.Printf("Successfully uploaded log to S3: %s", filename)
-			return
+			return nil
 		}
 
 		// Exponential backoff
@@ -56,39 +104,113 @@ func (l *S3Logger) UploadLog(filename string, content string) {
 
 	// All retries failed, fallback to local storage
 	log.Printf("Failed to upload log to S3 after %d attempts. Saving locally.", maxRetries)
-	l.saveLogLocally(filename, content)
+	return l.saveLogLocally(filename, content)
 }
 
-// uploadToS3 performs the actual upload to S3.
-func (l *S3Logger) uploadToS3(filename string, content string) error {
-	_, err := l.client.PutObject(context.TODO(), &s3.PutObjectInput{
-		Bucket: &l.bucket,
-		Key:    &filename,
-		Body:   bytes.NewReader([]byte(content)),
-	})
+// uploadToS3 streams content to S3 from its start, in multiple parts if it is large, gzipping it
+// on the way when compression is enabled. The uncompressed size is stored as object metadata.
+func (l *S3Logger) uploadToS3(filename string, content io.ReadSeeker) error {
+	size, err := content.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	input := &s3.PutObjectInput{
+		Bucket:      &l.bucket,
+		Key:         &filename,
+		Body:        content,
+		ContentType: awsString("text/plain; charset=utf-8"),
+		Metadata:    map[string]string{originalSizeMetadata: strconv.FormatInt(size, 10)},
+	}
+
+	if !l.compress {
+		_, err = l.uploader.Upload(context.TODO(), input)
+		return err
+	}
+
+	// Compress through a pipe so that only the parts being uploaded are held in memory
+	pr, pw := io.Pipe()
+	compressed := make(chan struct{})
+	go func() {
+		defer close(compressed)
+		gz := gzip.NewWriter(pw)
+		_, err := io.Copy(gz, content)
+		if closeErr := gz.Close(); err == nil {
+			err = closeErr
+		}
+		pw.CloseWithError(err)
+	}()
+
+	input.Body = pr
+	input.ContentEncoding = awsString(gzipEncoding)
+	_, err = l.uploader.Upload(context.TODO(), input)
+
+	// Stop the compressor if the upload gave up early, and wait for it so that a retry can rewind content
+	pr.CloseWithError(io.ErrClosedPipe)
+	<-compressed
 	return err
 }
 
-// saveLogLocally saves logs locally when S3 uploads fail.
-func (l *S3Logger) saveLogLocally(filename string, content string) {
-	localFilePath := fmt.Sprintf("local_logs/%s", filename)
-	if err := os.MkdirAll("local_logs", os.ModePerm); err != nil {
-		log.Printf("Failed to create local log directory: %v", err)
-		return
+// checkLogSize confirms that the object stored under key holds a log of size uncompressed bytes:
+// its metadata must record that size, and an uncompressed object must be exactly that long.
+func (l *S3Logger) checkLogSize(key string, size int64) error {
+	head, err := l.client.HeadObject(context.TODO(), &s3.HeadObjectInput{
+		Bucket: &l.bucket,
+		Key:    &key,
+	})
+	if err != nil {
+		return fmt.Errorf("checking uploaded log %s: %w", key, err)
+	}
+	if stored := head.Metadata[originalSizeMetadata]; stored != strconv.FormatInt(size, 10) {
+		return fmt.Errorf("uploaded log %s holds %s bytes, want %d", key, stored, size)
 	}
+	if head.ContentEncoding == nil || *head.ContentEncoding != gzipEncoding {
+		var length int64
+		if head.ContentLength != nil {
+			length = *head.ContentLength
+		}
+		if length != size {
+			return fmt.Errorf("uploaded log %s is %d bytes long, want %d", key, length, size)
+		}
+	}
+	return nil
+}
 
-	file, err := os.Create(localFilePath)
-	if err != nil {
-		log.Printf("Failed to create local log file: %v", err)
-		return
+// saveLogLocally saves logs under LocalFallbackDir when S3 uploads fail, mirroring the key's path,
+// together with a metadata sidecar that records the key so the log can be replayed to it later.
+func (l *S3Logger) saveLogLocally(filename string, content io.ReadSeeker) error {
+	l.fallbackMu.Lock()
+	defer l.fallbackMu.Unlock()
+
+	if !fs.ValidPath(filename) || filename == "." {
+		err := fmt.Errorf("invalid log key %q", filename)
+		log.Printf("Failed to save log locally: %v", err)
+		return err
 	}
-	defer file.Close()
+	localFilePath := filepath.Join(LocalFallbackDir, filepath.FromSlash(filename))
 
-	if _, err := file.WriteString(content); err != nil {
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(localFilePath, content); err != nil {
 		log.Printf("Failed to write log content locally: %v", err)
-	} else {
-		log.Printf("Log saved locally: %s", localFilePath)
+		return err
 	}
+
+	meta, err := json.Marshal(fallbackMeta{Key: filename, Bucket: l.bucket, SavedAt: time.Now()})
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(localFilePath+fallbackMetaSuffix, bytes.NewReader(meta)); err != nil {
+		log.Printf("Failed to write log metadata locally: %v", err)
+		return err
+	}
+
+	log.Printf("Log saved locally: %s", localFilePath)
+	return nil
 }
 
 // Helper function to convert string to *string (since AWS SDK requires pointers)
@@ -96,42 +218,183 @@ func awsString(value string) *string {
 	return &value
 }
 
+// Helper function to convert int32 to *int32 (since AWS SDK requires pointers)
+func awsInt32(value int32) *int32 {
+	return &value
+}
+
 // ListLogFiles lists all log files for a specific task in S3.
 func (l *S3Logger) ListLogFiles(prefix string) ([]string, error) {
-	output, err := l.client.ListObjectsV2(context.TODO(), &s3.ListObjectsV2Input{
-		Bucket: &l.bucket,
-		Prefix: &prefix,
-	})
+	objects, err := l.ListLogObjects(prefix, "", 0)
 	if err != nil {
-		log.Printf("Failed to list objects in S3 with prefix %s: %v", prefix, err)
 		return nil, err
 	}
 
 	var logFiles []string
-	for _, obj := range output.Contents {
-		logFiles = append(logFiles, *obj.Key)
+	for _, object := range objects {
+		logFiles = append(logFiles, object.Key)
 	}
 
 	return logFiles, nil
 }
 
-// FetchLogContent fetches the content of a log file from S3.
-func (l *S3Logger) FetchLogContent(key string) (string, error) {
+// ListLogObjects lists the log files in S3 that start with prefix and sort after startAfter,
+// following ListObjectsV2 pagination until limit objects have been found.
+func (l *S3Logger) ListLogObjects(prefix, startAfter string, limit int) ([]LogObject, error) {
+	input := &s3.ListObjectsV2Input{
+		Bucket: &l.bucket,
+		Prefix: &prefix,
+	}
+	if startAfter != "" {
+		input.StartAfter = &startAfter
+	}
+	if limit > 0 && limit < 1000 {
+		input.MaxKeys = awsInt32(int32(limit))
+	}
+
+	var objects []LogObject
+	paginator := s3.NewListObjectsV2Paginator(l.client, input)
+	for paginator.HasMorePages() && (limit <= 0 || len(objects) < limit) {
+		output, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			log.Printf("Failed to list objects in S3 with prefix %s: %v", prefix, err)
+			return nil, err
+		}
+
+		for _, obj := range output.Contents {
+			object := LogObject{Key: *obj.Key}
+			if obj.Size != nil {
+				object.Size = *obj.Size
+			}
+			if obj.LastModified != nil {
+				object.LastModified = *obj.LastModified
+			}
+			objects = append(objects, object)
+		}
+	}
+
+	if limit > 0 && len(objects) > limit {
+		objects = objects[:limit]
+	}
+	return objects, nil
+}
+
+// OpenLog streams a log file from S3, decompressing it if it was uploaded with gzip.
+func (l *S3Logger) OpenLog(key string) (io.ReadCloser, error) {
 	output, err := l.client.GetObject(context.TODO(), &s3.GetObjectInput{
 		Bucket: &l.bucket,
 		Key:    &key,
 	})
 	if err != nil {
 		log.Printf("Failed to fetch log content for key %s: %v", key, err)
+		return nil, err
+	}
+
+	if output.ContentEncoding == nil || *output.ContentEncoding != gzipEncoding {
+		return output.Body, nil
+	}
+
+	gz, err := gzip.NewReader(output.Body)
+	if err != nil {
+		output.Body.Close()
+		return nil, fmt.Errorf("decompressing log %s: %w", key, err)
+	}
+	return &gzipReadCloser{Reader: gz, body: output.Body}, nil
+}
+
+// OpenLogRange streams part of a log file from S3. The object's encoding is looked up first:
+// uncompressed logs are fetched with a ranged GET, while compressed logs have to be decompressed
+// from the start, so the bytes before offset are read and discarded.
+func (l *S3Logger) OpenLogRange(key string, offset, length int64) (io.ReadCloser, error) {
+	if length == 0 {
+		return io.NopCloser(bytes.NewReader(nil)), nil
+	}
+	if offset == 0 && length < 0 {
+		return l.OpenLog(key)
+	}
+
+	head, err := l.client.HeadObject(context.TODO(), &s3.HeadObjectInput{
+		Bucket: &l.bucket,
+		Key:    &key,
+	})
+	if err != nil {
+		log.Printf("Failed to fetch log metadata for key %s: %v", key, err)
+		return nil, err
+	}
+
+	if head.ContentEncoding != nil && *head.ContentEncoding == gzipEncoding {
+		// A range past the end needs no download when the uncompressed size is known
+		if size, err := strconv.ParseInt(head.Metadata[originalSizeMetadata], 10, 64); err == nil && offset >= size {
+			return io.NopCloser(bytes.NewReader(nil)), nil
+		}
+		body, err := l.OpenLog(key)
+		if err != nil {
+			return nil, err
+		}
+		return skipAndLimit(body, offset, length)
+	}
+
+	byteRange := fmt.Sprintf("bytes=%d-", offset)
+	if length > 0 {
+		byteRange = fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)
+	}
+	output, err := l.client.GetObject(context.TODO(), &s3.GetObjectInput{
+		Bucket:  &l.bucket,
+		Key:     &key,
+		Range:   &byteRange,
+		IfMatch: head.ETag, // The encoding checked above must still be the object's
+	})
+	var apiErr interface{ ErrorCode() string }
+	if errors.As(err, &apiErr) && apiErr.ErrorCode() == "InvalidRange" {
+		// The range starts past the end of the log
+		return io.NopCloser(bytes.NewReader(nil)), nil
+	}
+	if err != nil {
+		log.Printf("Failed to fetch log content for key %s: %v", key, err)
+		return nil, err
+	}
+	return output.Body, nil
+}
+
+// FetchLogContent fetches the content of a log file from S3.
+func (l *S3Logger) FetchLogContent(key string) (string, error) {
+	body, err := l.OpenLog(key)
+	if err != nil {
 		return "", err
 	}
-	defer output.Body.Close()
+	defer body.Close()
 
 	buf := new(bytes.Buffer)
-	_, err = buf.ReadFrom(output.Body)
+	_, err = buf.ReadFrom(body)
 	if err != nil {
 		return "", err
 	}
 
 	return buf.String(), nil
 }
+
+// gzipReadCloser decompresses an object body and closes both when done.
+type gzipReadCloser struct {
+	*gzip.Reader
+	body io.ReadCloser
+}
+
+func (g *gzipReadCloser) Close() error {
+	gzErr := g.Reader.Close()
+	if err := g.body.Close(); err != nil {
+		return err
+	}
+	return gzErr
+}
+
+// DeleteLog deletes a log file from S3.
+func (l *S3Logger) DeleteLog(key string) error {
+	_, err := l.client.DeleteObject(context.TODO(), &s3.DeleteObjectInput{
+		Bucket: &l.bucket,
+		Key:    &key,
+	})
+	if err != nil {
+		log.Printf("Failed to delete log %s from S3: %v", key, err)
+	}
+	return err
+}